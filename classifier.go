@@ -0,0 +1,413 @@
+// classifier.go
+
+package deep6
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nats-io/nuid"
+	"github.com/tidwall/gjson"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+//
+// n3id strategies for objects with no id of their own
+//
+const (
+	n3idStrategyNuid = "nuid"
+	n3idStrategyHash = "hash"
+)
+
+//
+// finds the {{path}} placeholders in an n3id template
+//
+var templatePlaceholder = regexp.MustCompile(`{{([^{}]*)}}`)
+
+//
+// Definition of a data model, used to classify inbound objects.
+//
+// Classifiers are normally declared in the [[classifier]] section
+// of ./config/datatypes.toml (see comments there for details of
+// each field), and can also be added from code through the
+// database's ClassifierRegistry.
+//
+type Classifier struct {
+	// name of the data model, must be unique
+	DataModel string `toml:"data_model"`
+	// paths that must all exist in an object of this model
+	RequiredPaths []string `toml:"required_paths"`
+	// path to the unique id of the object,
+	// if empty or missing the id is derived by N3idTemplate
+	// or N3idStrategy
+	N3id string `toml:"n3id"`
+	// template for the id of an object with no value at the N3id
+	// path, each {{path}} is replaced by the value at the path
+	// e.g. "{{Lesson.subject}}-{{Lesson.lesson_id}}"
+	N3idTemplate string `toml:"n3id_template"`
+	// how to assign an id if there is none in the object and the
+	// template cannot be filled: "nuid" (default) assigns a new
+	// unique id each time, "hash" derives a stable id from the
+	// content of the object
+	N3idStrategy string `toml:"n3id_strategy"`
+	// paths of the features of the object to link into the graph,
+	// '#' and '*' match any array index and any key (see linkspec.go)
+	Links []string `toml:"links"`
+	// whether links can match the end of a path rather than
+	// the whole of it, e.g. "Name.FamilyName" for
+	// StudentPersonal.PersonInfo.Name.FamilyName, or "RefId" for
+	// the RefId at any depth (see linkspec.go)
+	LinkSuffixes bool `toml:"link_suffixes"`
+	// normalisers applied to the values of a link before they are
+	// compared with other values, keyed by link e.g.
+	// {"actor.mbox": ["strip_mailto", "lowercase"]} (see normalise.go)
+	Normalise map[string][]string `toml:"normalise"`
+	// paths of the values combined into a unique
+	// linking key for the object
+	Unique []string `toml:"unique"`
+	// conditions on the values of the object, all must hold
+	Match []MatchRule `toml:"match"`
+	// classifiers are checked highest priority first,
+	// those with equal priority in the order they were declared
+	Priority int `toml:"priority"`
+	// constant type for all objects of this data model
+	Type string `toml:"type"`
+	// path to the value to use as the object type, used
+	// if Type is not set; if the path is missing from an object
+	// the type is derived from the object as usual
+	TypePath string `toml:"type_path"`
+	// JSON Schema file that objects of this model are validated
+	// against, a relative path is from the config folder
+	Schema string `toml:"schema"`
+	// what to do with an object that fails validation:
+	// "reject" (default) drops it, "quarantine" sends it to the
+	// dead-letter queue and "annotate" stores it with its
+	// validation errors (see schemavalidator.go)
+	OnInvalid string `toml:"on_invalid"`
+
+	schema *gojsonschema.Schema
+}
+
+//
+// A condition on the value found at a path in an object.
+//
+// With neither Equals nor Regex set the rule tests that the
+// path exists, Absent tests that it does not, and Not
+// inverts the result of the rule.
+//
+type MatchRule struct {
+	Path   string  `toml:"path"`
+	Equals *string `toml:"equals"`
+	Regex  string  `toml:"regex"`
+	Absent bool    `toml:"absent"`
+	Not    bool    `toml:"not"`
+
+	re *regexp.Regexp
+}
+
+//
+// checks that the classifier is usable, and compiles
+// the regular expressions of its match rules.
+//
+func (cl *Classifier) validate() error {
+
+	if cl.DataModel == "" {
+		return fmt.Errorf("classifier has no data_model")
+	}
+	if len(cl.RequiredPaths) == 0 {
+		return fmt.Errorf("classifier %s: no required_paths", cl.DataModel)
+	}
+
+	paths := make([]string, 0)
+	paths = append(paths, cl.RequiredPaths...)
+	paths = append(paths, cl.Unique...)
+	paths = append(paths, cl.Links...)
+	if cl.N3id != "" {
+		paths = append(paths, cl.N3id)
+	}
+	if cl.TypePath != "" {
+		paths = append(paths, cl.TypePath)
+	}
+	templatePaths, err := parseN3idTemplate(cl.N3idTemplate)
+	if err != nil {
+		return fmt.Errorf("classifier %s: %v", cl.DataModel, err)
+	}
+	paths = append(paths, templatePaths...)
+	switch cl.OnInvalid {
+	case "", onInvalidReject, onInvalidQuarantine, onInvalidAnnotate:
+	default:
+		return fmt.Errorf("classifier %s: unknown on_invalid %q", cl.DataModel, cl.OnInvalid)
+	}
+	switch cl.N3idStrategy {
+	case "", n3idStrategyNuid, n3idStrategyHash:
+	default:
+		return fmt.Errorf("classifier %s: unknown n3id_strategy %q", cl.DataModel, cl.N3idStrategy)
+	}
+	for link, names := range cl.Normalise {
+		if !containsString(cl.Links, link) {
+			return fmt.Errorf("classifier %s: normalise given for %s, which is not one of its links", cl.DataModel, link)
+		}
+		for _, name := range names {
+			if _, ok := normalisers[name]; !ok {
+				return fmt.Errorf("classifier %s: unknown normaliser %q for %s", cl.DataModel, name, link)
+			}
+		}
+	}
+	for _, rule := range cl.Match {
+		if rule.Path == "" {
+			return fmt.Errorf("classifier %s: match rule has no path", cl.DataModel)
+		}
+		paths = append(paths, rule.Path)
+	}
+	for _, path := range paths {
+		if err := validatePath(path); err != nil {
+			return fmt.Errorf("classifier %s: %v", cl.DataModel, err)
+		}
+	}
+
+	for i := range cl.Match {
+		rule := &cl.Match[i]
+		if rule.Regex == "" {
+			continue
+		}
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return fmt.Errorf("classifier %s: invalid regex for %s: %v", cl.DataModel, rule.Path, err)
+		}
+		rule.re = re
+	}
+
+	return nil
+
+}
+
+//
+// loads and compiles the classifier's schema, if it has one
+//
+// dir - folder that relative schema paths are found in
+//
+func (cl *Classifier) loadSchema(dir string) error {
+
+	cl.schema = nil
+	if cl.Schema == "" {
+		return nil
+	}
+	path := cl.Schema
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("classifier %s: cannot read schema: %v", cl.DataModel, err)
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(b))
+	if err != nil {
+		return fmt.Errorf("classifier %s: invalid schema %s: %v", cl.DataModel, cl.Schema, err)
+	}
+	cl.schema = schema
+	return nil
+
+}
+
+//
+// catches the common mistakes in a gjson path; gjson itself
+// accepts any string, and a malformed path silently never matches.
+//
+func validatePath(path string) error {
+
+	if strings.TrimSpace(path) == "" {
+		return fmt.Errorf("empty path")
+	}
+	if strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+		return fmt.Errorf("invalid path %q: empty path component", path)
+	}
+	if strings.HasSuffix(path, "|") || strings.Contains(path, "||") {
+		return fmt.Errorf("invalid path %q: empty path component", path)
+	}
+	depth := map[rune]int{}
+	pairs := map[rune]rune{')': '(', ']': '[', '}': '{'}
+	escaped := false
+	for _, r := range path {
+		if escaped {
+			escaped = false
+			continue
+		}
+		switch r {
+		case '\\':
+			escaped = true
+		case '(', '[', '{':
+			depth[r]++
+		case ')', ']', '}':
+			depth[pairs[r]]--
+			if depth[pairs[r]] < 0 {
+				return fmt.Errorf("invalid path %q: unbalanced %c", path, r)
+			}
+		}
+	}
+	for open, n := range depth {
+		if n != 0 {
+			return fmt.Errorf("invalid path %q: unbalanced %c", path, open)
+		}
+	}
+
+	return nil
+
+}
+
+//
+// reports whether the object (as json) has all of the
+// required paths and passes all of the match rules.
+//
+func (cl *Classifier) matches(rawJson []byte) bool {
+
+	for _, r := range gjson.GetManyBytes(rawJson, cl.RequiredPaths...) {
+		if !r.Exists() {
+			return false
+		}
+	}
+	for _, rule := range cl.Match {
+		if !rule.holds(rawJson) {
+			return false
+		}
+	}
+	return true
+
+}
+
+//
+// returns the id for the object: the value at the n3id path,
+// or if that is missing the filled template, or failing that
+// an id assigned according to the n3id strategy; fromContent
+// is set if the id is derived from the content of the object.
+//
+func (cl *Classifier) n3id(rawJson []byte) (id string, fromContent bool) {
+
+	if cl.N3id != "" {
+		if result := gjson.GetBytes(rawJson, cl.N3id); result.Exists() {
+			return result.String(), false
+		}
+	}
+	if id, ok := fillN3idTemplate(cl.N3idTemplate, rawJson); ok {
+		return id, false
+	}
+	if cl.N3idStrategy == n3idStrategyHash {
+		return contentId(cl.DataModel, rawJson), true
+	}
+	return nuid.Next(), false
+
+}
+
+//
+// returns the object type given by the classifier,
+// empty if the type should be derived from the object.
+//
+func (cl *Classifier) objectType(rawJson []byte) string {
+
+	if cl.Type != "" {
+		return cl.Type
+	}
+	if cl.TypePath == "" {
+		return ""
+	}
+	return gjson.GetBytes(rawJson, cl.TypePath).String()
+
+}
+
+//
+// applies a single match rule to the object
+//
+func (rule MatchRule) holds(rawJson []byte) bool {
+
+	r := gjson.GetBytes(rawJson, rule.Path)
+	ok := r.Exists()
+	if rule.Absent {
+		ok = !ok
+	}
+	if ok && rule.Equals != nil {
+		ok = r.String() == *rule.Equals
+	}
+	if ok && rule.re != nil {
+		ok = rule.re.MatchString(r.String())
+	}
+	return ok != rule.Not
+
+}
+
+//
+// returns the paths used by an n3id template, an error if
+// the template has stray braces or an empty placeholder.
+//
+func parseN3idTemplate(template string) ([]string, error) {
+
+	paths := make([]string, 0)
+	for _, m := range templatePlaceholder.FindAllStringSubmatch(template, -1) {
+		path := strings.TrimSpace(m[1])
+		if path == "" {
+			return nil, fmt.Errorf("invalid n3id_template %q: empty placeholder", template)
+		}
+		paths = append(paths, path)
+	}
+	rest := templatePlaceholder.ReplaceAllString(template, "")
+	if strings.Contains(rest, "{{") || strings.Contains(rest, "}}") {
+		return nil, fmt.Errorf("invalid n3id_template %q: unbalanced braces", template)
+	}
+	return paths, nil
+
+}
+
+//
+// fills the template with values from the object, ok is
+// false if there is no template or any of its paths is missing
+//
+func fillN3idTemplate(template string, rawJson []byte) (string, bool) {
+
+	if template == "" {
+		return "", false
+	}
+	ok := true
+	id := templatePlaceholder.ReplaceAllStringFunc(template, func(m string) string {
+		path := strings.TrimSpace(m[2 : len(m)-2])
+		result := gjson.GetBytes(rawJson, path)
+		if !result.Exists() || result.String() == "" {
+			ok = false
+		}
+		return result.String()
+	})
+	return id, ok
+
+}
+
+//
+// derives a stable id from the content of an object; the object
+// json is marshalled from a map so its keys are always in sorted
+// order, the same content gives the same id whatever the order of
+// the original input.
+//
+func contentId(dataModel string, rawJson []byte) string {
+
+	h := sha256.New()
+	h.Write([]byte(dataModel))
+	h.Write([]byte{0})
+	h.Write(rawJson)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+
+}
+
+//
+// reports whether the list holds the string
+//
+func containsString(list []string, s string) bool {
+
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+
+}
@@ -0,0 +1,361 @@
+// classifierregistry.go
+
+package deep6
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+//
+// The classifiers (and collection and link rules) used by ingest,
+// loaded from ./config/datatypes.toml when the database is
+// opened, plus any classifiers registered from code.
+//
+// Each pipeline takes a snapshot of the registry as it starts,
+// so changes, including a reload of the config file, are
+// swapped in atomically and only affect later ingests.
+//
+type ClassifierRegistry struct {
+	// guards changes; readers use the current snapshot
+	mu sync.Mutex
+	// holds the current *classifierConfig
+	current atomic.Value
+	// classifiers from the config file
+	fromFile []Classifier
+	// collection rules from the config file
+	collections []collectionRule
+	// link value rules from the config file
+	links linkRules
+	// classifiers added through Register()
+	registered []Classifier
+	// location of the config file
+	configFile string
+	// modification time of the config file when last loaded
+	loaded time.Time
+	// modification time of the config file when last
+	// checked by a watcher, valid or not
+	checked time.Time
+}
+
+//
+// an immutable snapshot of the registry, as used by a pipeline
+//
+type classifierConfig struct {
+	// all classifiers in the order they are checked
+	classifiers []*Classifier
+	// collection unwrapping rules
+	collections []collectionRule
+	// stop-list and hub threshold for link values
+	links linkRules
+}
+
+//
+// content of the config file
+//
+type classifierFile struct {
+	Classifier []Classifier
+	Collection []collectionRule
+	Links      linkRules
+}
+
+//
+// creates the registry from the config file
+// in the database folder.
+//
+func newClassifierRegistry(folderPath string) (*ClassifierRegistry, error) {
+
+	cr := &ClassifierRegistry{
+		configFile: fmt.Sprintf("%s/config/datatypes.toml", folderPath),
+		registered: make([]Classifier, 0),
+	}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+
+}
+
+//
+// Adds a classifier, it is used by all ingests
+// started after it is registered.
+//
+// Returns an error if the classifier is not valid, or has the
+// same data model as a classifier already in the registry.
+//
+func (cr *ClassifierRegistry) Register(c Classifier) error {
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	registered := append(append(make([]Classifier, 0), cr.registered...), c)
+	cfg, err := buildClassifierConfig(cr.configDir(), cr.fromFile, registered, cr.collections, cr.links)
+	if err != nil {
+		return errors.Wrap(err, "cannot register classifier:")
+	}
+	cr.registered = registered
+	cr.current.Store(cfg)
+
+	return nil
+
+}
+
+//
+// Removes the classifier for the given data model, whether it
+// was registered from code or loaded from the config file;
+// a classifier from the file is loaded again by ReloadConfig().
+//
+// Returns an error if there is no classifier for the data model.
+//
+func (cr *ClassifierRegistry) Remove(dataModel string) error {
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	registered, foundRegistered := withoutModel(cr.registered, dataModel)
+	fromFile, foundFile := withoutModel(cr.fromFile, dataModel)
+	if !foundRegistered && !foundFile {
+		return errors.New("no classifier for data model: " + dataModel)
+	}
+	cfg, err := buildClassifierConfig(cr.configDir(), fromFile, registered, cr.collections, cr.links)
+	if err != nil {
+		return err
+	}
+	cr.registered = registered
+	cr.fromFile = fromFile
+	cr.current.Store(cfg)
+
+	return nil
+
+}
+
+//
+// Lists the classifiers in the order they are checked.
+//
+func (cr *ClassifierRegistry) List() []Classifier {
+
+	cfg := cr.config()
+	list := make([]Classifier, 0, len(cfg.classifiers))
+	for _, c := range cfg.classifiers {
+		list = append(list, *c)
+	}
+	return list
+
+}
+
+//
+// returns the current snapshot of the registry
+//
+func (cr *ClassifierRegistry) config() *classifierConfig {
+	return cr.current.Load().(*classifierConfig)
+}
+
+//
+// loads the config file again, replacing the classifiers,
+// collection and link rules it defined; the config is validated
+// first, and left in place if not valid.
+//
+func (cr *ClassifierRegistry) reload() error {
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	info, err := os.Stat(cr.configFile)
+	if err != nil {
+		return errors.Wrap(err, "cannot read classifier config:")
+	}
+	var f classifierFile
+	if _, err := toml.DecodeFile(cr.configFile, &f); err != nil {
+		return errors.Wrap(err, "cannot decode classifier config:")
+	}
+	collections := f.Collection
+	if len(collections) == 0 {
+		collections = defaultCollectionRules
+	}
+	cfg, err := buildClassifierConfig(cr.configDir(), f.Classifier, cr.registered, collections, f.Links)
+	if err != nil {
+		return errors.Wrap(err, "invalid classifier config:")
+	}
+	cr.fromFile = f.Classifier
+	cr.collections = collections
+	cr.links = f.Links
+	cr.loaded = info.ModTime()
+	cr.current.Store(cfg)
+
+	return nil
+
+}
+
+//
+// reloads the config file if it has changed since it was last loaded
+//
+func (cr *ClassifierRegistry) reloadIfChanged() error {
+
+	info, err := os.Stat(cr.configFile)
+	if err != nil {
+		return errors.Wrap(err, "cannot read classifier config:")
+	}
+	cr.mu.Lock()
+	changed := !info.ModTime().Equal(cr.loaded) && !info.ModTime().Equal(cr.checked)
+	cr.checked = info.ModTime()
+	cr.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return cr.reload()
+
+}
+
+//
+// returns the value along with each of its forms under the
+// normalisers used by the classifiers, so that a lookup finds
+// objects that hold the value in any of its normalised forms
+//
+func (cfg *classifierConfig) valueForms(value string) []string {
+
+	forms := []string{value}
+	seen := map[string]struct{}{value: {}}
+	for _, c := range cfg.classifiers {
+		for _, names := range c.Normalise {
+			form := normaliseValue(names, value)
+			if _, ok := seen[form]; ok || form == "" {
+				continue
+			}
+			seen[form] = struct{}{}
+			forms = append(forms, form)
+		}
+	}
+	return forms
+
+}
+
+//
+// folder holding the config file, where relative
+// schema paths are found
+//
+func (cr *ClassifierRegistry) configDir() string {
+	return filepath.Dir(cr.configFile)
+}
+
+//
+// validates a set of classifiers and assembles them
+// into a snapshot, in the order they are checked.
+//
+// dir - folder that relative schema paths are found in
+//
+func buildClassifierConfig(dir string, fromFile, registered []Classifier, collections []collectionRule, links linkRules) (*classifierConfig, error) {
+
+	if links.MaxDegree < 0 {
+		return nil, fmt.Errorf("links: max_degree cannot be negative")
+	}
+	cfg := &classifierConfig{
+		classifiers: make([]*Classifier, 0, len(fromFile)+len(registered)),
+		collections: collections,
+		links:       links.prepare(),
+	}
+	models := make(map[string]struct{})
+	for _, list := range [][]Classifier{fromFile, registered} {
+		for i := range list {
+			c := list[i] // copy, so the snapshot cannot be changed
+			c.Match = append([]MatchRule(nil), c.Match...)
+			if c.Normalise != nil {
+				normalise := make(map[string][]string, len(c.Normalise))
+				for link, names := range c.Normalise {
+					normalise[link] = append([]string(nil), names...)
+				}
+				c.Normalise = normalise
+			}
+			if err := c.validate(); err != nil {
+				return nil, err
+			}
+			if err := c.loadSchema(dir); err != nil {
+				return nil, err
+			}
+			if _, ok := models[c.DataModel]; ok {
+				return nil, fmt.Errorf("duplicate classifier for data model %s", c.DataModel)
+			}
+			models[c.DataModel] = struct{}{}
+			cfg.classifiers = append(cfg.classifiers, &c)
+		}
+	}
+	sort.SliceStable(cfg.classifiers, func(i, j int) bool {
+		return cfg.classifiers[i].Priority > cfg.classifiers[j].Priority
+	})
+
+	return cfg, nil
+
+}
+
+//
+// returns the classifiers without the given data model,
+// and whether it was found
+//
+func withoutModel(classifiers []Classifier, dataModel string) ([]Classifier, bool) {
+
+	kept := make([]Classifier, 0, len(classifiers))
+	found := false
+	for _, c := range classifiers {
+		if c.DataModel == dataModel {
+			found = true
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return kept, found
+
+}
+
+//
+// Reloads the classifier config file, replacing the classifiers
+// it defines; classifiers registered from code are kept.
+//
+// The new config is validated before it is swapped in, if it
+// is not valid an error is returned and the current
+// classifiers stay in use.
+//
+func (d6 *Deep6DB) ReloadConfig() error {
+	return d6.Classifiers.reload()
+}
+
+//
+// Watches the classifier config file, reloading it whenever
+// it changes. The file is checked every interval, an invalid
+// config is logged and ignored until the file changes again.
+//
+// The watch stops when the database is closed, or when
+// the returned stop function is called.
+//
+func (d6 *Deep6DB) WatchConfig(interval time.Duration) (stop func()) {
+
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() { once.Do(func() { close(done) }) }
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := d6.Classifiers.reloadIfChanged(); err != nil {
+					log.Println("classifier config not reloaded: ", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	d6.watchers = append(d6.watchers, stop)
+	return stop
+
+}
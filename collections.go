@@ -0,0 +1,178 @@
+// collections.go
+
+package deep6
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+//
+// rule for unwrapping a collection envelope,
+// see the [[collection]] section of ./config/datatypes.toml
+//
+type collectionRule struct {
+	Envelope string `toml:"envelope"`
+	Member   string `toml:"member"`
+}
+
+//
+// used if the config declares no collection rules, unwraps SIF
+// plural envelopes e.g. {"StudentPersonals":{"StudentPersonal":[...]}}
+//
+var defaultCollectionRules = []collectionRule{{Envelope: "*s", Member: "*"}}
+
+//
+// Expands collection envelopes from the source into their
+// member objects, so each member is classified and stored
+// as an object in its own right. Objects that are not
+// collections are passed on unchanged.
+//
+// Members keep the position of their envelope in the source.
+//
+// ctx - pipeline management context
+// rules - the collection rules from the classifier registry
+// in - channel providing json objects from the source
+//
+func collectionExpander(ctx context.Context, rules []collectionRule, in <-chan IngestData) (
+	<-chan IngestData, // emits objects and collection members
+	<-chan error, // emits errors encountered to the pipeline
+	error) { // any error encountered when creating this component
+
+	out := make(chan IngestData)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errc)
+
+		clock := stageClockFrom(ctx)
+		for igd := range in {
+			start := time.Now()
+			members, ok := unwrapCollection(rules, igd.RawData)
+			if !ok {
+				members = []map[string]interface{}{igd.RawData}
+			}
+			clock.add(start)
+			for _, m := range members {
+				select {
+				case out <- IngestData{RawData: m, Position: igd.Position}: // pass the data on to the next stage
+				case <-ctx.Done(): // listen for pipeline shutdown
+					return
+				}
+			}
+		}
+	}()
+
+	return out, errc, nil
+
+}
+
+//
+// returns the member objects of a collection envelope, ok is false
+// if the object does not match any of the collection rules.
+//
+func unwrapCollection(rules []collectionRule, obj map[string]interface{}) ([]map[string]interface{}, bool) {
+
+	if len(obj) != 1 {
+		return nil, false
+	}
+	var envelope string
+	var body interface{}
+	for k, v := range obj {
+		envelope, body = k, v
+	}
+
+	for _, rule := range rules {
+		capture, ok := matchEnvelope(rule.Envelope, envelope)
+		if !ok {
+			continue
+		}
+		if rule.Member == "" {
+			members, ok := objectList(body)
+			if !ok {
+				continue
+			}
+			return members, true
+		}
+		container, ok := body.(map[string]interface{})
+		if !ok || len(container) != 1 {
+			continue
+		}
+		memberKey := strings.Replace(rule.Member, "*", capture, 1)
+		items, ok := objectList(container[memberKey])
+		if !ok {
+			continue
+		}
+		members := make([]map[string]interface{}, 0, len(items))
+		for _, item := range items {
+			members = append(members, map[string]interface{}{memberKey: item})
+		}
+		return members, true
+	}
+
+	return nil, false
+
+}
+
+//
+// reports whether a collection rule unwraps the
+// envelope into members with the given key
+//
+func isCollectionMember(rules []collectionRule, envelope, member string) bool {
+
+	for _, rule := range rules {
+		capture, ok := matchEnvelope(rule.Envelope, envelope)
+		if !ok || rule.Member == "" {
+			continue
+		}
+		if strings.Replace(rule.Member, "*", capture, 1) == member {
+			return true
+		}
+	}
+	return false
+
+}
+
+//
+// matches a key against an envelope pattern, returns the
+// text matched by the * if the pattern has one.
+//
+func matchEnvelope(pattern, key string) (string, bool) {
+
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return "", pattern == key
+	}
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	if len(key) <= len(prefix)+len(suffix) || !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) {
+		return "", false
+	}
+	return key[len(prefix) : len(key)-len(suffix)], true
+
+}
+
+//
+// returns the members of a collection, which may be a single
+// object or an array of objects.
+//
+func objectList(v interface{}) ([]map[string]interface{}, bool) {
+
+	switch members := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{members}, true
+	case []interface{}:
+		list := make([]map[string]interface{}, 0, len(members))
+		for _, member := range members {
+			m, ok := member.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			list = append(list, m)
+		}
+		return list, true
+	}
+	return nil, false
+
+}
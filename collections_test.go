@@ -0,0 +1,113 @@
+// collections_test.go
+
+package deep6
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUnwrapCollection(t *testing.T) {
+
+	bare := []collectionRule{{Envelope: "statements", Member: ""}}
+	named := []collectionRule{{Envelope: "Batch", Member: "Lesson"}}
+
+	tests := []struct {
+		name   string
+		rules  []collectionRule
+		object string
+		want   string // json of the members, empty if not a collection
+	}{
+		{"sif plural envelope", defaultCollectionRules,
+			`{"StudentPersonals":{"StudentPersonal":[{"RefId":"P1"},{"RefId":"P2"}]}}`,
+			`[{"StudentPersonal":{"RefId":"P1"}},{"StudentPersonal":{"RefId":"P2"}}]`},
+		{"single member", defaultCollectionRules,
+			`{"StudentPersonals":{"StudentPersonal":{"RefId":"P1"}}}`,
+			`[{"StudentPersonal":{"RefId":"P1"}}]`},
+		{"empty member list", defaultCollectionRules,
+			`{"StudentPersonals":{"StudentPersonal":[]}}`,
+			`[]`},
+		{"member not named for the envelope", defaultCollectionRules,
+			`{"Settings":{"Colour":{"name":"red"}}}`, ``},
+		{"envelope with other keys", defaultCollectionRules,
+			`{"StudentPersonals":{"StudentPersonal":[{"RefId":"P1"}],"count":1}}`, ``},
+		{"members must be objects", defaultCollectionRules,
+			`{"Names":{"Name":["Ann","Bob"]}}`, ``},
+		{"not a single key", defaultCollectionRules,
+			`{"StudentPersonals":{"StudentPersonal":[]},"SchoolInfos":{"SchoolInfo":[]}}`, ``},
+		{"envelope of bare objects", bare,
+			`{"statements":[{"id":"1"},{"id":"2"}]}`,
+			`[{"id":"1"},{"id":"2"}]`},
+		{"bare envelope, not a list", bare,
+			`{"statements":"none"}`, ``},
+		{"named envelope", named,
+			`{"Batch":{"Lesson":[{"id":"1"}]}}`,
+			`[{"Lesson":{"id":"1"}}]`},
+		{"named envelope, other member", named,
+			`{"Batch":{"Unit":[{"id":"1"}]}}`, ``},
+		{"rules in order, plural first", append(append([]collectionRule{}, defaultCollectionRules...), bare...),
+			`{"statements":{"statement":[{"id":"1"}]}}`,
+			`[{"statement":{"id":"1"}}]`},
+		{"rules in order, bare first", append(append([]collectionRule{}, bare...), defaultCollectionRules...),
+			`{"statements":{"statement":[{"id":"1"}]}}`,
+			`[{"statement":[{"id":"1"}]}]`},
+		{"no rules", nil,
+			`{"StudentPersonals":{"StudentPersonal":[{"RefId":"P1"}]}}`, ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var obj map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.object), &obj); err != nil {
+				t.Fatal(err)
+			}
+			members, ok := unwrapCollection(tt.rules, obj)
+			if !ok {
+				if tt.want != "" {
+					t.Errorf("not unwrapped, want %s", tt.want)
+				}
+				return
+			}
+			if tt.want == "" {
+				t.Errorf("unwrapped into %v, want not a collection", members)
+				return
+			}
+			got, err := json.Marshal(members)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestMatchEnvelope(t *testing.T) {
+
+	tests := []struct {
+		pattern, key string
+		wantCapture  string
+		wantOk       bool
+	}{
+		{"Batch", "Batch", "", true},
+		{"Batch", "Batches", "", false},
+		{"*s", "StudentPersonals", "StudentPersonal", true},
+		{"*s", "s", "", false},
+		{"*s", "Address", "Addres", true},
+		{"*List", "OtherIdList", "OtherId", true},
+		{"xapi-*", "xapi-statements", "statements", true},
+		{"xapi-*", "statements", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.key, func(t *testing.T) {
+			capture, ok := matchEnvelope(tt.pattern, tt.key)
+			if ok != tt.wantOk || capture != tt.wantCapture {
+				t.Errorf("got %q, %v, want %q, %v", capture, ok, tt.wantCapture, tt.wantOk)
+			}
+		})
+	}
+
+}
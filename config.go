@@ -80,24 +80,139 @@ var classifierConfigText = `
 # n3id is the field to be used for unique identification of an
 # object in the data store, if empty n3 will assign a unique id.
 # 
+# n3id_template builds the id for objects without an n3id field
+# from values in the object, each {{path}} is replaced by the value
+# at the path e.g. n3id_template = "{{Lesson.subject}}-{{Lesson.lesson_id}}"
+# 
+# if there is no n3id field and the template cannot be filled
+# n3id_strategy decides the id: "nuid" (the default) assigns a new
+# id each time, so re-ingesting the object creates a copy, "hash"
+# derives the id from the content of the object, so re-ingesting
+# the same object replaces it
+# 
 # links are the features of the object to connect to the 
-# overall data graph
+# overall data graph, each is a path matched exactly against the
+# flattened paths of the object's values: segments are separated
+# by '.', '#' matches any array index and '*' matches any single
+# key or index e.g. "StudentPersonal.OtherIdList.OtherId.#.value";
+# a path to an array of values links every value in the array
+# 
+# link_suffixes = true lets a link also match the end of a path,
+# so "RefId" matches the RefId at any depth e.g. StudentPersonal.RefId,
+# and "Name.FamilyName" matches StudentPersonal.PersonInfo.Name.FamilyName,
+# whole keys only, so "RefId" does not match SchoolInfoRefId
+# 
+# configs written before links were matched as paths listed links
+# that matched anywhere in a path, e.g. "RefId" also linked the
+# value of StudentPersonal.SchoolInfoRefId; to keep them linking,
+# set link_suffixes = true (or give a leading wildcard for each
+# level above the link, e.g. "*.PersonInfo.Name.FamilyName"), list
+# the keys that held part of a link as links too, e.g. "SchoolInfoRefId"
+# as well as "RefId", and replace a link that matched part of a path,
+# e.g. "PersonInfo.Name", with a link for each value below it,
+# e.g. "Name.GivenName" and "Name.FamilyName"; the SIF classifier
+# below lists the references found in SIF data this way
+# 
+# normalise lists normalisers for the values of a link, applied in
+# order before the values are compared with those of other objects,
+# so e.g. mailto:Jane@School.edu links to jane@school.edu; the
+# normalisers are lowercase, trim, strip_mailto, nfc (unicode normal
+# form), url (canonical url) and strip_leading_zeros e.g.
+# 
+# [classifier.normalise]
+# "actor.mbox" = ["strip_mailto", "lowercase"]
 # 
 # unique fields are those used to construct a unique linking key
 # for the object if no suitable single property is available
 # 
+# match rules test the values of an object, all must hold as well
+# as the required paths; each [[classifier.match]] has a path and
+# optionally equals = "value" and/or regex = "pattern", with
+# neither the path need only exist; absent = true requires the
+# path to be missing, and not = true inverts the rule e.g.
+# 
+# [[classifier.match]]
+# path = "verb.id"
+# regex = "^http://adlnet.gov/expapi/verbs/"
+# 
+# priority decides which classifier wins if an object matches
+# more than one (highest first, default 0), classifiers of equal
+# priority are checked in file order; objects that match more than
+# one classifier are counted in the Ambiguous field of the ingest report
+# 
+# the object type is normally the single top-level key of the object
+# (e.g. SIF StudentPersonal), or the data model for objects with
+# many keys (e.g. XAPI); type sets a constant type for the classifier,
+# or type_path takes the type from a value in the object e.g.
+# type_path = "object.definition.type"
+# 
+# schema names a JSON Schema file that objects of the data model
+# are validated against, a relative path is from this config folder
+# e.g. schema = "schemas/xapi-statement.json"; on_invalid decides
+# what happens to an object that fails: "reject" (the default) drops
+# it, "quarantine" sends it to the dead-letter queue (the ingest
+# must be tolerant, otherwise the ingest stops) and "annotate"
+# stores it with its errors under schema-errors; invalid objects are
+# listed in the Invalid and ValidationFailures fields of the report
+# 
+# 
+# Collection section unwraps envelopes that carry many objects
+# so that each member is stored as an object in its own right
+# 
+# envelope is the single top-level key of a collection object,
+# member is the key within the envelope that holds the member
+# object or array of objects, each member is emitted wrapped
+# in its member key e.g. {"StudentPersonal":{...}}
 # 
+# a * in envelope matches any text, which is substituted
+# for the * in member; if member is empty the envelope holds
+# an array of bare objects which are emitted as they are
+# 
+# the rules also pick out the envelopes of xml data, an element is
+# an envelope if a rule unwraps it into members named as its first
+# child element, e.g. <StudentPersonals><StudentPersonal>...
+# 
+# if no collections are given the SIF plural rule below is used
+# 
+# 
+# Links section protects the graph from over-connected values
+# 
+# stop_values are values that are never used to link objects, however
+# many objects hold them; they are compared exactly (after any normalise)
+# 
+# a value held by more than max_degree objects is flagged as a hub
+# and no longer used to link (0 for no limit); hubs are listed by
+# the Hubs() api, along with the properties that hold them, as a
+# guide to tuning the links of the classifiers
+# 
+# by default no values are stopped and there is no limit, so every
+# value links as before; uncomment and tune e.g.
+# 
+[links]
+# stop_values = ["true", "false", "yes", "no", "null", "0", "1", "en-US"]
+# max_degree = 5000
+
+[[collection]]
+envelope = "*s"
+member = "*"
+
 [[classifier]]
 data_model = "SIF"
 required_paths = ["*.RefId"]
 n3id = "*.RefId"
-links = ["RefId","LocalId"]
+links = ["RefId", "LocalId",
+	"SchoolInfoRefId", "StudentPersonalRefId", "StaffPersonalRefId",
+	"TeachingGroupRefId", "TimeTableSubjectRefId", "SchoolCourseInfoRefId",
+	"StudentLocalId", "StaffLocalId", "SubjectLocalId", "LearningStandardLocalId"]
+link_suffixes = true
 
 [[classifier]]
 data_model = "XAPI"
 required_paths = ["actor.name", "actor.mbox", "object.id", "verb.id"]
 n3id = "id"
 links = ["actor.mbox","actor.name","object.id","object.definition.name"]
+[classifier.normalise]
+"actor.mbox" = ["strip_mailto", "lowercase"]
 
 [[classifier]]
 data_model = "Syllabus"
@@ -117,6 +232,7 @@ unique = ["Subject.subject", "Subject.stage"]
 data_model = "Lesson"
 required_paths = ["Lesson.learning_area", "Lesson.lesson_id"]
 n3id = "id"
+n3id_template = "{{Lesson.subject}}-{{Lesson.lesson_id}}"
 links = ["Lesson.learning_area", "Lesson.subject", "Lesson.stage"]
 unique = ["Lesson.subject","Lesson.stage"]
 
@@ -150,4 +266,14 @@ data_model = "OtfScale"
 required_paths = ["progressionLevel", "partiallyAchieved"]
 n3id = "scaleItemId"
 links = ["progressionLevel"]
+
+# identity crosswalk records, each declares a set of identifiers
+# of the same entity e.g. a RefId, a LocalId and an xAPI mbox, so
+# the objects holding them are linked (see AddSameAs())
+[[classifier]]
+data_model = "SameAs"
+required_paths = ["sameAs"]
+n3id_strategy = "hash"
+type = "SameAs"
+links = ["sameAs"]
 `
@@ -0,0 +1,82 @@
+// contenthash.go
+
+package deep6
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/dgraph-io/badger"
+)
+
+//
+// key prefix for the content hash stored for each object
+//
+const contentHashPrefix = "hash|"
+
+//
+// Derives a hash of the canonical form of an object, used
+// to recognise objects that are unchanged when data is reloaded.
+//
+// The hash covers the object data and the classification that
+// governs how it is linked, so an object is also re-processed
+// if its classifier config (data model, type or type_path, links,
+// unique fields) has been changed since it was stored.
+//
+// json.Marshal writes map keys in sorted order, so the
+// encoding of the same data is always the same.
+//
+func contentHash(igd IngestData) (string, error) {
+
+	canonical := struct {
+		DataModel    string
+		Type         string
+		LinkSpecs    []string
+		LinkSuffixes bool
+		Normalise    map[string][]string
+		Unique       string
+		Data         map[string]interface{}
+	}{
+		DataModel:    igd.DataModel,
+		Type:         igd.Type,
+		LinkSpecs:    igd.LinkSpecs,
+		LinkSuffixes: igd.LinkSuffixes,
+		Normalise:    igd.Normalise,
+		Unique:       igd.Unique,
+		Data:         igd.RawData,
+	}
+	b, err := json.Marshal(canonical)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:]), nil
+
+}
+
+//
+// returns the content hash stored for the object with the given id,
+// empty string if the object has no stored hash.
+//
+func storedContentHash(id string, db *badger.DB) (string, error) {
+
+	var hash string
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(contentHashPrefix + id))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			hash = string(val)
+			return nil
+		})
+	})
+
+	return hash, err
+
+}
@@ -0,0 +1,160 @@
+// crosswalk.go
+
+package deep6
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+)
+
+//
+// An identity crosswalk declares that a set of identifier values,
+// e.g. a SIF RefId, a LocalId, an xAPI mbox and an LMS userId,
+// all identify the same entity although no object holds more
+// than one of them.
+//
+// Each set is stored as an object of type SameAs, {"sameAs":[...]},
+// classified by the SameAs classifier of the default config. Its
+// values are links, so it is linked to every object holding one of
+// them, and those links carry a sameAs edge (see linkBuilder()).
+// Traversals pass through SameAs objects, so the objects holding
+// the values are treated as linked to each other directly
+// (see traverseLinks()).
+//
+const (
+	// object type of a crosswalk record
+	sameAsType = "SameAs"
+	// label of the edges to and from a crosswalk record
+	sameAsEdgeLabel = "sameAs"
+	// key holding the equivalent values in a crosswalk record
+	sameAsKey = "sameAs"
+)
+
+//
+// Declares that the values identify the same entity.
+//
+// The values are stored as a crosswalk record, whose id
+// is derived from the set of values so declaring the same
+// set again leaves the graph unchanged.
+//
+// Returns an error if there are fewer than two distinct
+// values, or if the classifier config has no classifier for
+// crosswalk records (see the SameAs classifier in the
+// default config).
+//
+func (d6 *Deep6DB) AddSameAs(values ...string) (*IngestReport, error) {
+
+	record, err := sameAsRecord(values)
+	if err != nil {
+		return nil, err
+	}
+	return d6.ingestCrosswalk([][]byte{record})
+
+}
+
+//
+// Loads an identity crosswalk from csv, each row is a set of
+// values that identify the same entity e.g.
+//
+// 0FA4E7A3-1C6B-4C43-8C3F-3F6B2A1D1E10,12345,mailto:jane@school.edu,u-998
+//
+// Rows need not have the same number of values, empty values
+// are ignored and rows with fewer than two values are skipped.
+//
+// Returns a report of the crosswalk records ingested, or an
+// error if the csv cannot be read, in which case nothing
+// is ingested.
+//
+func (d6 *Deep6DB) IngestCrosswalk(r io.Reader) (*IngestReport, error) {
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read crosswalk:")
+	}
+
+	records := make([][]byte, 0, len(rows))
+	for _, row := range rows {
+		record, err := sameAsRecord(row)
+		if err != nil {
+			continue // nothing to declare
+		}
+		records = append(records, record)
+	}
+	return d6.ingestCrosswalk(records)
+
+}
+
+//
+// ingests crosswalk records as json objects
+//
+func (d6 *Deep6DB) ingestCrosswalk(records [][]byte) (*IngestReport, error) {
+
+	classified := false
+	for _, c := range d6.Classifiers.config().classifiers {
+		if c.Type == sameAsType {
+			classified = true
+			break
+		}
+	}
+	if !classified {
+		return nil, errors.New("no classifier for crosswalk records, add the SameAs classifier to the classifier config")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	buf.Write(bytes.Join(records, []byte(",")))
+	buf.WriteString("]")
+	return d6.IngestFromReader(&buf)
+
+}
+
+//
+// returns the crosswalk record for a set of values, which
+// are trimmed, de-duplicated and sorted so that the same set
+// always gives the same record
+//
+func sameAsRecord(values []string) ([]byte, error) {
+
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = struct{}{}
+		}
+	}
+	if len(set) < 2 {
+		return nil, errors.New("a crosswalk needs at least two distinct values")
+	}
+	sorted := make([]string, 0, len(set))
+	for v := range set {
+		sorted = append(sorted, v)
+	}
+	sort.Strings(sorted)
+	return json.Marshal(map[string][]string{sameAsKey: sorted})
+
+}
+
+//
+// reports whether the object with the id is a crosswalk record
+//
+func isCrosswalk(txn *badger.Txn, id string) (bool, error) {
+
+	_, err := txn.Get([]byte("spo|" + id + "|is-a|" + sameAsType))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+
+}
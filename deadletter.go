@@ -0,0 +1,360 @@
+// deadletter.go
+
+package deep6
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/nats-io/nuid"
+	"github.com/pkg/errors"
+)
+
+//
+// key prefix for dead letters stored in the database
+//
+const deadLetterPrefix = "dlq|"
+
+//
+// formats of the raw bytes of a dead letter
+//
+const (
+	DeadLetterJSON = "json"
+	DeadLetterXML  = "xml"
+)
+
+//
+// An object rejected by a tolerant ingest, with
+// enough context to find, fix and replay it.
+//
+type DeadLetter struct {
+	// unique id of this dead letter
+	Id string
+	// position of the object in its source stream (0 based)
+	Position int
+	// byte offset in the source stream at which the
+	// failure was detected, -1 if not known
+	Offset int64
+	// the raw bytes of the object; for a stream that ends
+	// part way through an object this is the rest of the stream,
+	// as it is for malformed xml, which the reader cannot read
+	// past, opened by the start tag of the collection the object
+	// was in, if any, so that it can be replayed once fixed
+	Raw string
+	// format of Raw, DeadLetterJSON or DeadLetterXML; dead
+	// letters stored before formats were recorded are json
+	Format string
+	// why the object was rejected
+	Reason string
+	// the pipeline stage that rejected the object
+	Stage string
+	// when the object was rejected
+	Time time.Time
+}
+
+//
+// Collects the objects rejected by an ingest, writing them
+// either to the database under the dead-letter prefix
+// or as json lines to a side file.
+//
+// A nil queue means the ingest is not tolerant, and stages
+// should stop the pipeline on any error.
+//
+type deadLetterQueue struct {
+	db     *badger.DB
+	file   string
+	report *IngestReport
+	mu     sync.Mutex
+}
+
+//
+// returns the dead-letter queue for an ingest,
+// nil unless the db is in tolerant mode.
+//
+func (d6 *Deep6DB) deadLetterQueue(report *IngestReport) *deadLetterQueue {
+	if !d6.Tolerant {
+		return nil
+	}
+	return &deadLetterQueue{db: d6.db, file: d6.DeadLetterFile, report: report}
+}
+
+//
+// Captures a bad object in the queue.
+//
+// Returns true if the object was captured and the pipeline can
+// carry on, false if there is no queue (ingest is not tolerant)
+// or the dead letter could not be stored, in which case the
+// caller should treat the failure as an error.
+//
+func (q *deadLetterQueue) reject(position int, offset int64, raw []byte, stage string, reason error) bool {
+	return q.rejectAs(DeadLetterJSON, position, offset, raw, stage, reason)
+}
+
+//
+// Captures a bad object whose raw bytes are in the given
+// format, see reject().
+//
+func (q *deadLetterQueue) rejectAs(format string, position int, offset int64, raw []byte, stage string, reason error) bool {
+
+	if q == nil {
+		return false
+	}
+
+	dl := DeadLetter{
+		Id:       nuid.Next(),
+		Position: position,
+		Offset:   offset,
+		Raw:      string(raw),
+		Format:   format,
+		Reason:   reason.Error(),
+		Stage:    stage,
+		Time:     time.Now(),
+	}
+	if err := q.store(dl); err != nil {
+		log.Println("cannot store dead letter: ", err)
+		return false
+	}
+
+	if q.report != nil {
+		q.report.mu.Lock()
+		q.report.DeadLetters++
+		q.report.mu.Unlock()
+	}
+
+	return true
+
+}
+
+//
+// notes in the report that the source was not read to the
+// end, the rest of it having been captured as a dead letter
+//
+func (q *deadLetterQueue) truncated() {
+
+	if q == nil || q.report == nil {
+		return
+	}
+	q.report.mu.Lock()
+	q.report.Truncated = true
+	q.report.mu.Unlock()
+
+}
+
+//
+// writes the dead letter to the side file if one is
+// configured, otherwise to the database.
+//
+func (q *deadLetterQueue) store(dl DeadLetter) error {
+
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	if q.file == "" {
+		return q.db.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte(deadLetterPrefix+dl.Id), b)
+		})
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f, err := os.OpenFile(q.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, string(b))
+	return err
+
+}
+
+//
+// Lists all dead letters held in the database, and in the
+// dead-letter file if one is set, oldest first.
+//
+func (d6 *Deep6DB) DeadLetters() ([]DeadLetter, error) {
+
+	dls := make([]DeadLetter, 0)
+	err := d6.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := []byte(deadLetterPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var dl DeadLetter
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &dl)
+			})
+			if err != nil {
+				return err
+			}
+			dls = append(dls, dl)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read dead letters from db:")
+	}
+
+	fileDls, err := readDeadLetterFile(d6.DeadLetterFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read dead letter file:")
+	}
+	dls = append(dls, fileDls...)
+
+	sort.SliceStable(dls, func(i, j int) bool { return dls[i].Time.Before(dls[j].Time) })
+
+	return dls, nil
+
+}
+
+//
+// Removes the dead letters with the given ids from
+// the database and the dead-letter file.
+//
+func (d6 *Deep6DB) RemoveDeadLetters(ids ...string) error {
+
+	remove := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		remove[id] = struct{}{}
+	}
+
+	err := d6.db.Update(func(txn *badger.Txn) error {
+		for id := range remove {
+			if err := txn.Delete([]byte(deadLetterPrefix + id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot remove dead letters from db:")
+	}
+
+	if d6.DeadLetterFile == "" {
+		return nil
+	}
+	fileDls, err := readDeadLetterFile(d6.DeadLetterFile)
+	if err != nil {
+		return errors.Wrap(err, "cannot read dead letter file:")
+	}
+	f, err := os.Create(d6.DeadLetterFile)
+	if err != nil {
+		return errors.Wrap(err, "cannot rewrite dead letter file:")
+	}
+	defer f.Close()
+	for _, dl := range fileDls {
+		if _, ok := remove[dl.Id]; ok {
+			continue
+		}
+		b, err := json.Marshal(dl)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(f, string(b)); err != nil {
+			return errors.Wrap(err, "cannot rewrite dead letter file:")
+		}
+	}
+
+	return nil
+
+}
+
+//
+// Re-ingests all dead letters, oldest first.
+//
+// Each dead letter is ingested in tolerant mode, so one that
+// still fails is captured again as a new dead letter with the
+// latest reason. A dead letter is only taken off the queue once
+// it has been ingested (or captured again), so if the replay
+// stops with an error the dead letters not yet replayed are kept.
+//
+// Xml dead letters are read as xml; one that is still malformed
+// is captured again along with whatever follows the fault.
+//
+func (d6 *Deep6DB) ReplayDeadLetters() (*IngestReport, error) {
+
+	defer timeTrack(time.Now(), "ReplayDeadLetters()")
+
+	dls, err := d6.DeadLetters()
+	if err != nil {
+		return nil, err
+	}
+
+	report := newIngestReport()
+	defer report.finish()
+	dlq := &deadLetterQueue{db: d6.db, file: d6.DeadLetterFile, report: report}
+
+	for _, dl := range dls {
+		if dl.Format == DeadLetterXML {
+			err = runIngestWithReader(context.Background(), d6.db, d6.iwb, d6.sbf, strings.NewReader(dl.Raw), d6.AuditLevel, d6.Classifiers, d6.pipeline(), d6.IngestWorkers, report, dlq)
+		} else {
+			c := make(chan []byte, 1)
+			c <- []byte(dl.Raw)
+			close(c)
+			err = runIngestWithIterator(context.Background(), d6.db, d6.iwb, d6.sbf, c, d6.AuditLevel, d6.Classifiers, d6.pipeline(), d6.IngestWorkers, report, dlq)
+		}
+		// ensure the writer finishes
+		flushErr := d6.iwb.Flush()
+		// reinstate the writer
+		d6.iwb = d6.db.NewWriteBatch()
+		if err != nil {
+			return report, errors.Wrap(err, "error replaying dead letters:")
+		}
+		if flushErr != nil {
+			return report, errors.Wrap(flushErr, "error replaying dead letters:")
+		}
+		if err := d6.RemoveDeadLetters(dl.Id); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+
+}
+
+//
+// reads dead letters from a json-lines side file,
+// a missing file holds no dead letters.
+//
+func readDeadLetterFile(fname string) ([]DeadLetter, error) {
+
+	dls := make([]DeadLetter, 0)
+	if fname == "" {
+		return dls, nil
+	}
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return dls, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var dl DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &dl); err != nil {
+			return nil, err
+		}
+		dls = append(dls, dl)
+	}
+
+	return dls, scanner.Err()
+
+}
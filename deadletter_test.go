@@ -0,0 +1,70 @@
+// deadletter_test.go
+
+package deep6
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplayXMLDeadLetters(t *testing.T) {
+
+	d6 := openTestDeep6(t)
+	d6.Tolerant = true
+
+	bad := `<StudentPersonals><StudentPersonal RefId="P1"/><StudentPersonal RefId="P2"><LocalId>L2</Local></StudentPersonal><StudentPersonal RefId="P3"/></StudentPersonals>`
+	report, err := d6.IngestFromReader(strings.NewReader(bad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Truncated || report.ObjectsRead != 1 {
+		t.Errorf("read %d objects, truncated %v, want 1 and truncated", report.ObjectsRead, report.Truncated)
+	}
+	dls, err := d6.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dls) != 1 || dls[0].Format != DeadLetterXML {
+		t.Fatalf("got dead letters %v, want one xml dead letter", dls)
+	}
+
+	// still malformed, so captured again in place of the first
+	report, err = d6.ReplayDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := d6.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DeadLetters != 1 || len(again) != 1 || again[0].Id == dls[0].Id || again[0].Raw != dls[0].Raw {
+		t.Fatalf("replay captured %d, left %v, want the dead letter captured again", report.DeadLetters, again)
+	}
+
+	// once fixed the rest of the stream is ingested
+	if err := d6.RemoveDeadLetters(again[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	fixed := again[0]
+	fixed.Raw = strings.Replace(fixed.Raw, "</Local>", "</LocalId>", 1)
+	dlq := &deadLetterQueue{db: d6.db}
+	if err := dlq.store(fixed); err != nil {
+		t.Fatal(err)
+	}
+	report, err = d6.ReplayDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ObjectsRead != 2 || report.DeadLetters != 0 {
+		t.Errorf("replay read %d objects and captured %d, want 2 and 0", report.ObjectsRead, report.DeadLetters)
+	}
+	for _, id := range []string{"P1", "P2", "P3"} {
+		if _, err := findById(id, d6.db); err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+	if left, _ := d6.DeadLetters(); len(left) != 0 {
+		t.Errorf("%d dead letters left after replay", len(left))
+	}
+
+}
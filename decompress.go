@@ -0,0 +1,91 @@
+// decompress.go
+
+package deep6
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+//
+// magic bytes that open each supported compressed format
+//
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+//
+// Wraps the reader so that gzip or zstd compressed streams
+// are decompressed on the fly as the pipeline reads them.
+//
+// The format is detected from the magic bytes at the start
+// of the stream, uncompressed streams are passed through
+// untouched. Nothing is buffered beyond the reader's
+// normal read-ahead.
+//
+// Close releases any decoder resources, it does not
+// close the underlying reader.
+//
+func decompressReader(r io.Reader) (io.ReadCloser, error) {
+
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzipReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return zstdReader(br)
+	}
+
+	return ioutil.NopCloser(br), nil
+
+}
+
+//
+// Wraps the reader to decode the given http Content-Encoding;
+// gzip and zstd are supported, identity (or no encoding)
+// falls back to detecting compression from the stream itself.
+//
+func decodeContentEncoding(r io.Reader, encoding string) (io.ReadCloser, error) {
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		return gzipReader(r)
+	case "zstd":
+		return zstdReader(r)
+	case "", "identity":
+		return decompressReader(r)
+	}
+
+	return nil, errors.New("unsupported content-encoding: " + encoding)
+
+}
+
+func gzipReader(r io.Reader) (io.ReadCloser, error) {
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create gzip reader:")
+	}
+	return zr, nil
+
+}
+
+func zstdReader(r io.Reader) (io.ReadCloser, error) {
+
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create zstd reader:")
+	}
+	return zr.IOReadCloser(), nil
+
+}
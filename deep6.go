@@ -17,7 +17,6 @@ import (
 	"os"
 
 	"github.com/dgraph-io/badger"
-	boom "github.com/tylertreat/BoomFilters"
 )
 
 type Deep6DB struct {
@@ -36,15 +35,57 @@ type Deep6DB struct {
 	//
 	// sbf used to record links
 	//
-	sbf *boom.ScalableBloomFilter
+	sbf *linkFilter
 	//
 	// set level of audit ouput, one of: none, basic, high
+	// defaults to high, set to none to turn the output off;
+	// ingest results are also returned as an IngestReport
 	//
 	AuditLevel string
 	//
+	// number of parallel workers used by the expensive
+	// ingest stages (classification, tuple generation, linking),
+	// defaults to 1; every stage runs as a single goroutine
+	//
+	IngestWorkers int
+	//
+	// tolerant ingest; objects that cannot be decoded or
+	// classified are set aside in a dead-letter queue
+	// rather than stopping the whole ingest; malformed xml
+	// cannot be read past, so it is set aside with the rest
+	// of its stream (see IngestReport.Truncated)
+	//
+	Tolerant bool
+	//
+	// if set, dead letters are written as json lines to this
+	// file, otherwise they are stored in the database
+	//
+	DeadLetterFile string
+	//
+	// the classifiers used to identify inbound objects,
+	// loaded from ./config/datatypes.toml when the database
+	// is opened, see ReloadConfig() and WatchConfig()
+	//
+	Classifiers *ClassifierRegistry
+	//
+	// the stages ingested objects pass through, nil uses
+	// the built-in stages, see NewPipeline()
+	//
+	Pipeline *Pipeline
+	//
+	// include xAPI statements that have been voided in the
+	// results of queries and traversals, by default they are
+	// hidden but can still be fetched with FindById()
+	//
+	IncludeVoided bool
+	//
 	// location of the database
 	//
 	folderPath string
+	//
+	// stops any config watchers when the db is closed
+	//
+	watchers []func()
 }
 
 //
@@ -81,15 +122,22 @@ func OpenFromFile(folderPath string) (*Deep6DB, error) {
 		return nil, err
 	}
 
+	classifiers, err := newClassifierRegistry(folderPath)
+	if err != nil {
+		return nil, err
+	}
+
 	log.Println("...d6 database open")
 
 	return &Deep6DB{
-		db:         db,
-		iwb:        iwb,
-		rwb:        rwb,
-		sbf:        sbf,
-		AuditLevel: "high",
-		folderPath: folderPath}, nil
+		db:            db,
+		iwb:           iwb,
+		rwb:           rwb,
+		sbf:           sbf,
+		AuditLevel:    "high",
+		IngestWorkers: 1,
+		Classifiers:   classifiers,
+		folderPath:    folderPath}, nil
 }
 
 //
@@ -110,6 +158,10 @@ func Open() (*Deep6DB, error) {
 func (d6 *Deep6DB) Close() {
 	log.Println("closing d6 database...")
 
+	for _, stop := range d6.watchers {
+		stop()
+	}
+
 	err := d6.iwb.Flush()
 	if err != nil {
 		log.Println("error flushing ingest writebatch: ", err)
@@ -3,13 +3,10 @@
 package deep6
 
 import (
-	"bytes"
-	"encoding/json"
 	"time"
 
 	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
-	boom "github.com/tylertreat/BoomFilters"
 )
 
 //
@@ -19,7 +16,7 @@ func (d6 *Deep6DB) Delete(id string) error {
 
 	defer timeTrack(time.Now(), "Delete()")
 
-	err := deleteWithID(id, d6.db, d6.rwb, d6.sbf, d6.AuditLevel, d6.folderPath)
+	err := deleteWithID(id, d6.db, d6.rwb, d6.AuditLevel)
 	if err != nil {
 		return errors.Wrap(err, "cannot delete object: "+id)
 	}
@@ -32,28 +29,29 @@ func (d6 *Deep6DB) Delete(id string) error {
 
 }
 
-func deleteWithID(id string, db *badger.DB, wb *badger.WriteBatch, sbf *boom.ScalableBloomFilter, auditLevel, folderPath string) error {
+func deleteWithID(id string, db *badger.DB, wb *badger.WriteBatch, auditLevel string) error {
 
 	// see if object exists
 	obj, err := findById(id, db)
 	if err != nil {
 		return err
 	}
-	psuedoStream := []map[string]interface{}{obj}
-
-	//
-	// pass object through the remove pipeline
-	// by converting to a readable byte array
-	// as if comming from a stream
-	// add obj to []mapstringiface first then marshal
-	//
-	json, err := json.Marshal(psuedoStream)
+
+	// now run the remove sequence
+	err = runRemoveWithId(db, wb, id, auditLevel)
 	if err != nil {
-		return errors.Wrap(err, "found id object but could not marshal as json.")
+		return err
 	}
-	r := bytes.NewReader(json)
 
-	// now run the remove sequence
-	return runRemoveWithReader(db, wb, sbf, r, auditLevel, folderPath)
+	// removing a voiding statement lifts the void
+	if target := voidTarget(obj); target != "" {
+		if err := wb.Delete([]byte(voidedPrefix + target)); err != nil {
+			return err
+		}
+	}
+
+	// remove the content hash so a later ingest
+	// of the same object is not skipped
+	return wb.Delete([]byte(contentHashPrefix + id))
 
 }
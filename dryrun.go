@@ -0,0 +1,278 @@
+// dryrun.go
+
+package deep6
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//
+// the writes made by pipeline stages,
+// satisfied by badger.WriteBatch.
+//
+type batchWriter interface {
+	Set(k, v []byte) error
+	Delete(k []byte) error
+}
+
+//
+// batchWriter that throws away all writes
+//
+type discardWriter struct{}
+
+func (discardWriter) Set(k, v []byte) error { return nil }
+func (discardWriter) Delete(k []byte) error { return nil }
+
+//
+// link tracer that records new traces locally, on top of those
+// held in the shared filter, so objects in a dry run see the
+// traces left by objects before them without changing the
+// filter used by real ingests.
+//
+type overlayFilter struct {
+	sync.Mutex
+	base  *linkFilter
+	added map[string]struct{}
+}
+
+func newOverlayFilter(base *linkFilter) *overlayFilter {
+	return &overlayFilter{base: base, added: make(map[string]struct{})}
+}
+
+func (of *overlayFilter) Add(data []byte) {
+	of.Lock()
+	of.added[string(data)] = struct{}{}
+	of.Unlock()
+}
+
+func (of *overlayFilter) Test(data []byte) bool {
+	of.Lock()
+	_, ok := of.added[string(data)]
+	of.Unlock()
+	return ok || of.base.Test(data)
+}
+
+//
+// What an ingest would do with one object.
+//
+type DryRunResult struct {
+	// position of the object in the source stream (0 based)
+	Position int
+	// classification of the object
+	N3id      string
+	Type      string
+	DataModel string
+	// other data models whose classifiers also matched
+	AlsoMatched []string
+	// link specs of the classifier that matched the object
+	LinkSpecs []string
+	// pseudo-unique key derived for the object, if any
+	Unique string
+	// errors found validating the object against the schema of
+	// its classifier, and what an ingest would do with it; invalid
+	// objects are still previewed in full
+	SchemaErrors []string
+	OnInvalid    string
+	// triples that would be written for the object
+	Triples []Triple
+	// properties of the object that could link it to
+	// the graph, including reverse links from objects
+	// that hold the same values
+	LinkCandidates []Triple
+	// links that would be written
+	LinkTriples []Triple
+	// labelled edges that would be written with the links
+	EdgeTriples []Triple
+	// values that would get a new Property.Link node
+	PropertyLinks []string
+}
+
+//
+// Previews an ingest without changing the database.
+//
+// Objects are classified, turned into triples and linked against
+// the live database as they would be by a real ingest, but no
+// triples, links or link traces are stored. Objects in the stream
+// can see the link traces of objects before them, but not their
+// triples, so links between objects that are only in the stream
+// will not be shown as they are not yet in the graph.
+//
+// The stages of the db's Pipeline are run, so objects are previewed
+// as changed by any stages the application has added; every object
+// is previewed as if new to the database.
+//
+// Returns the outcome for each object in stream order.
+//
+func (d6 *Deep6DB) IngestDryRun(r io.Reader) ([]DryRunResult, error) {
+
+	defer timeTrack(time.Now(), "IngestDryRun()")
+
+	dr, err := decompressReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read data:")
+	}
+	defer dr.Close()
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	var errcList []<-chan error
+
+	cfg := d6.Classifiers.config()
+
+	sourceOut, errc, err := readerSource(ctx, dr, cfg.collections, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error: cannot create reader source component: ")
+	}
+	errcList = append(errcList, errc)
+
+	//
+	// run the stages of the db's pipeline, the built-in stages
+	// are swapped for ones that write nothing, and those that
+	// only store or compare with stored data are left out
+	//
+	env := &ingestEnv{
+		db:      d6.db,
+		sbf:     d6.sbf,
+		cfg:     cfg,
+		workers: 1,
+		report:  &IngestReport{},
+		dryRun:  true,
+	}
+	ctx = context.WithValue(ctx, ingestEnvKey{}, env)
+	tracer := newOverlayFilter(d6.sbf)
+
+	head := sourceOut
+	for _, s := range d6.pipeline().list() {
+		var out <-chan IngestData
+		if b, ok := s.(*builtinStage); ok {
+			out, errc, err = dryRunStage(ctx, d6, cfg, tracer, b.name, head)
+		} else {
+			out, errc, err = s.Run(ctx, head)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error: cannot create %s component: ", s.Name())
+		}
+		if errc != nil {
+			errcList = append(errcList, errc)
+		}
+		head = out
+	}
+
+	results := make([]DryRunResult, 0)
+	errc, err = dryRunSink(ctx, &results, head)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error: cannot create dry-run-sink component: ")
+	}
+	errcList = append(errcList, errc)
+
+	err = WaitForPipeline(errcList...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error in dry run:")
+	}
+
+	return results, nil
+
+}
+
+//
+// Reports whether the stage is running as part of a dry run, in
+// which case it should not write to the database.
+//
+// ctx - the context the stage was started with
+//
+func IsDryRun(ctx context.Context) bool {
+
+	env := ingestEnvFrom(ctx)
+	return env != nil && env.dryRun
+
+}
+
+//
+// starts the dry run version of a built-in stage, those
+// that would store objects or compare them with what is
+// stored pass objects through unchanged.
+//
+func dryRunStage(ctx context.Context, d6 *Deep6DB, cfg *classifierConfig, tracer linkTracer, name string, in <-chan IngestData) (
+	<-chan IngestData, // pass data on to the next stage
+	<-chan error, // emits errors encountered to the pipeline, nil if not started
+	error) {
+
+	switch name {
+	case StageCollectionExpander:
+		return collectionExpander(ctx, cfg.collections, in)
+	case StageObjectClassifier:
+		return objectClassifier(ctx, cfg.classifiers, nil, in)
+	case StageSchemaValidator:
+		return schemaValidator(ctx, nil, nil, true, in)
+	case StageXapiStatements:
+		return xapiStatements(ctx, discardWriter{}, in)
+	case StageTupleGenerator:
+		return tupleGenerator(ctx, in)
+	case StageLinkParser:
+		return linkParser(ctx, tracer, in)
+	case StageReverseLinkChecker:
+		return linkReverseChecker(ctx, d6.db, discardWriter{}, cfg.links, in)
+	case StageLinkBuilder:
+		return linkBuilder(ctx, d6.db, discardWriter{}, in)
+	}
+	return in, nil, nil
+
+}
+
+//
+// terminator sink for the dry run, collects the
+// outcome for each object.
+//
+// ctx - pipeline management context
+// results - receives the outcome for each object
+// in - channel providing IngestData objects
+//
+func dryRunSink(ctx context.Context, results *[]DryRunResult, in <-chan IngestData) (
+	<-chan error, // emits errors encountered to the pipeline
+	error) { // any error encountered when creating this component
+
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+
+		for igd := range in {
+			*results = append(*results, DryRunResult{
+				Position:       igd.Position,
+				N3id:           igd.N3id,
+				Type:           igd.Type,
+				DataModel:      igd.DataModel,
+				AlsoMatched:    igd.AlsoMatched,
+				LinkSpecs:      igd.LinkSpecs,
+				Unique:         igd.Unique,
+				SchemaErrors:   igd.SchemaErrors,
+				OnInvalid:      dryRunAction(igd),
+				Triples:        igd.Triples,
+				LinkCandidates: igd.LinkCandidates,
+				LinkTriples:    igd.LinkTriples,
+				EdgeTriples:    igd.EdgeTriples,
+				PropertyLinks:  igd.PropertyLinks,
+			})
+		}
+	}()
+
+	return errc, nil
+
+}
+
+//
+// the action an ingest would take for an invalid object,
+// empty if the object is valid
+//
+func dryRunAction(igd IngestData) string {
+	if len(igd.SchemaErrors) == 0 {
+		return ""
+	}
+	return onInvalidAction(igd.OnInvalid)
+}
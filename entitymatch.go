@@ -0,0 +1,664 @@
+// entitymatch.go
+
+package deep6
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+)
+
+//
+// key prefix for matches accepted between objects,
+// stored as match|<id>|<other id> in both directions
+//
+const matchPrefix = "match|"
+
+//
+// ways of comparing the values of a match field
+//
+const (
+	// personal names, ignoring case, punctuation and word order
+	compareName = "name"
+	// email addresses, ignoring case and any mailto: scheme
+	compareEmail = "email"
+	// dates in common formats e.g. 2006-01-02, 02/01/2006
+	compareDate = "date"
+	// any text, ignoring case
+	compareText = "text"
+	// values must be equal, ignoring case
+	compareExact = "exact"
+)
+
+//
+// A job that looks for objects of two types that are probably
+// the same entity, e.g. xAPI actors and SIF students, but are not
+// linked as they share no identifier.
+//
+type MatchSpec struct {
+	// types of the objects to match, e.g. XAPI and StudentPersonal;
+	// may be the same type to look for duplicate records
+	FromType string
+	ToType   string
+	// the values compared, at least one is needed
+	Fields []MatchField
+	// least overall score (0 to 1) for a pair to be suggested,
+	// defaults to 0.85
+	MinScore float64
+	// most suggestions for each object of FromType,
+	// 0 keeps all
+	Limit int
+}
+
+//
+// A value compared between the objects of a match.
+//
+type MatchField struct {
+	// paths of the value in the objects of each type, matched
+	// against the flattened paths of the objects in the same way as
+	// link specs (see linkspec.go); if more than one path matches,
+	// the values are joined in the order of the paths, e.g. a
+	// given and family name compared with a full name
+	From []string
+	To   []string
+	// how the values are compared: name, email, date,
+	// text (the default) or exact
+	Compare string
+	// weight of the field in the overall score, defaults to 1
+	Weight float64
+}
+
+//
+// A pair of objects that are probably the same entity.
+//
+type MatchSuggestion struct {
+	// ids of the objects
+	From string
+	To   string
+	// weighted score of the fields compared, 0 to 1
+	Score float64
+	// score of each field in the order of the spec,
+	// -1 if either object has no value for the field
+	FieldScores []float64
+}
+
+//
+// the values of the match fields for an object
+//
+type matchCandidate struct {
+	id     string
+	values []string
+}
+
+//
+// Suggests matches between objects of the types in the spec that
+// are not already linked, directly or through a Property.Link they
+// share, most likely first.
+//
+// Every pair of objects is scored by comparing the values of the
+// fields; fields that either object has no value for are left out
+// of its score, and pairs with no fields in common are not
+// suggested. Voided xAPI statements are left out unless the db
+// includes voided statements.
+//
+// Matches can be accepted with AcceptMatch().
+//
+func (d6 *Deep6DB) SuggestMatches(spec MatchSpec) ([]MatchSuggestion, error) {
+
+	defer timeTrack(time.Now(), "SuggestMatches()")
+
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	minScore := spec.MinScore
+	if minScore == 0 {
+		minScore = 0.85
+	}
+
+	fromPaths := make([][]linkSpec, 0, len(spec.Fields))
+	toPaths := make([][]linkSpec, 0, len(spec.Fields))
+	for _, f := range spec.Fields {
+		fromPaths = append(fromPaths, parseLinkSpecs(f.From, nil))
+		toPaths = append(toPaths, parseLinkSpecs(f.To, nil))
+	}
+	from, err := d6.matchCandidates(spec.FromType, fromPaths)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read objects to match:")
+	}
+	to, err := d6.matchCandidates(spec.ToType, toPaths)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read objects to match:")
+	}
+	linked, err := d6.linkedPairs(from)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read links:")
+	}
+
+	suggestions := make([]MatchSuggestion, 0)
+	for _, f := range from {
+		found := make([]MatchSuggestion, 0)
+		for _, t := range to {
+			if f.id == t.id {
+				continue
+			}
+			if spec.FromType == spec.ToType && f.id > t.id {
+				continue // each pair once
+			}
+			if _, ok := linked[[2]string{f.id, t.id}]; ok {
+				continue
+			}
+			s, ok := spec.score(f, t)
+			if !ok || s.Score < minScore {
+				continue
+			}
+			found = append(found, s)
+		}
+		sortSuggestions(found)
+		if spec.Limit > 0 && len(found) > spec.Limit {
+			found = found[:spec.Limit]
+		}
+		suggestions = append(suggestions, found...)
+	}
+	sortSuggestions(suggestions)
+
+	return suggestions, nil
+
+}
+
+//
+// Accepts a match between two objects, linking them with an
+// explicit link. The match is kept, so the objects stay linked if
+// either is ingested again.
+//
+// Returns ErrNotFound if either object does not exist.
+//
+func (d6 *Deep6DB) AcceptMatch(from, to string) error {
+
+	if from == to {
+		return errors.New("cannot match an object with itself")
+	}
+	return d6.db.Update(func(txn *badger.Txn) error {
+		for _, id := range []string{from, to} {
+			if !objectExists(txn, id) {
+				return errors.Wrap(ErrNotFound, "cannot accept match: "+id)
+			}
+		}
+		keys := []string{matchPrefix + from + "|" + to, matchPrefix + to + "|" + from}
+		link := Triple{S: from, P: "references", O: to}
+		edge := Triple{S: from, P: edgePrefix + explicitEdgeLabel, O: to}
+		keys = append(keys, link.SextupleLink()...)
+		keys = append(keys, edge.SextupleLink()...)
+		for _, k := range keys {
+			if err := txn.Set([]byte(k), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+}
+
+//
+// returns the objects an object has accepted matches with,
+// leaving out any that no longer exist
+//
+// it - iterator of the transaction to read with
+//
+func acceptedMatches(txn *badger.Txn, it *badger.Iterator, id string) []string {
+
+	others := make([]string, 0)
+	prefix := []byte(matchPrefix + id + "|")
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		others = append(others, string(it.Item().Key()[len(prefix):]))
+	}
+	existing := make([]string, 0, len(others))
+	for _, other := range others {
+		if objectExists(txn, other) {
+			existing = append(existing, other)
+		}
+	}
+	return existing
+
+}
+
+//
+// reports whether there is an object with the id
+//
+func objectExists(txn *badger.Txn, id string) bool {
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	prefix := []byte("spo|" + id + "|is-a|")
+	it.Seek(prefix)
+	return it.ValidForPrefix(prefix)
+
+}
+
+//
+// checks that the spec can be run
+//
+func (spec MatchSpec) validate() error {
+
+	if spec.FromType == "" || spec.ToType == "" {
+		return errors.New("match spec needs a from and a to type")
+	}
+	if len(spec.Fields) == 0 {
+		return errors.New("match spec has no fields")
+	}
+	if spec.MinScore < 0 || spec.MinScore > 1 {
+		return fmt.Errorf("match spec: min score %v is not between 0 and 1", spec.MinScore)
+	}
+	for i, f := range spec.Fields {
+		if len(f.From) == 0 || len(f.To) == 0 {
+			return fmt.Errorf("match field %d: needs from and to paths", i)
+		}
+		for _, path := range append(append([]string(nil), f.From...), f.To...) {
+			if err := validatePath(path); err != nil {
+				return fmt.Errorf("match field %d: %v", i, err)
+			}
+		}
+		if _, ok := comparers[f.Compare]; !ok && f.Compare != "" {
+			return fmt.Errorf("match field %d: unknown compare %q", i, f.Compare)
+		}
+		if f.Weight < 0 {
+			return fmt.Errorf("match field %d: weight cannot be negative", i)
+		}
+	}
+	return nil
+
+}
+
+//
+// scores a pair of objects, ok is false if they
+// have no fields in common
+//
+func (spec MatchSpec) score(from, to matchCandidate) (MatchSuggestion, bool) {
+
+	s := MatchSuggestion{From: from.id, To: to.id, FieldScores: make([]float64, len(spec.Fields))}
+	total, weights := 0.0, 0.0
+	for i, f := range spec.Fields {
+		s.FieldScores[i] = -1
+		if from.values[i] == "" || to.values[i] == "" {
+			continue
+		}
+		compare := comparers[f.Compare]
+		if compare == nil {
+			compare = comparers[compareText]
+		}
+		weight := f.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		s.FieldScores[i] = compare(from.values[i], to.values[i])
+		total += weight * s.FieldScores[i]
+		weights += weight
+	}
+	if weights == 0 {
+		return s, false
+	}
+	s.Score = total / weights
+	return s, true
+
+}
+
+//
+// reads the ids and match field values of the objects of a type
+//
+// paths - parsed paths of each field for the type
+//
+func (d6 *Deep6DB) matchCandidates(typename string, paths [][]linkSpec) ([]matchCandidate, error) {
+
+	ids := make([]string, 0)
+	err := d6.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := []byte(fmt.Sprintf("pos|is-a|%s|", typename))
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			ids = append(ids, NewTriple(string(it.Item().KeyCopy(nil))).S)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !d6.IncludeVoided {
+		ids, err = withoutVoided(d6.db, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	candidates := make([]matchCandidate, 0, len(ids))
+	err = d6.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for _, id := range ids {
+			triples := make([]Triple, 0)
+			prefix := []byte(fmt.Sprintf("spo|%s|", id))
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				triples = append(triples, NewTriple(string(it.Item().KeyCopy(nil))))
+			}
+			c := matchCandidate{id: id, values: make([]string, len(paths))}
+			for i, specs := range paths {
+				// values in the order of the paths
+				// that found them
+				parts := make([]string, 0)
+				for j := range specs {
+					for _, t := range triples {
+						if matchLinkSpecs(specs[j:j+1], t.P, false) != nil {
+							parts = append(parts, t.O)
+						}
+					}
+				}
+				c.values[i] = strings.Join(parts, " ")
+			}
+			candidates = append(candidates, c)
+		}
+		return nil
+	})
+
+	return candidates, err
+
+}
+
+//
+// returns the pairs of objects, from those given and any other
+// object, that are already linked in either direction, either
+// directly or through a link node (Property.Link or Unique.Link)
+// they both link with, e.g. a value they share that is not the
+// id of an object
+//
+func (d6 *Deep6DB) linkedPairs(from []matchCandidate) (map[[2]string]struct{}, error) {
+
+	linked := make(map[[2]string]struct{})
+	err := d6.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		// objects linked with each link node, read once
+		shared := make(map[string][]string)
+		for _, c := range from {
+			for _, other := range linkedWith(it, c.id) {
+				linked[[2]string{c.id, other}] = struct{}{}
+				if !isLinkNode(it, other) {
+					continue
+				}
+				if _, ok := shared[other]; !ok {
+					shared[other] = linkedWith(it, other)
+				}
+				for _, sharer := range shared[other] {
+					linked[[2]string{c.id, sharer}] = struct{}{}
+				}
+			}
+		}
+		return nil
+	})
+	return linked, err
+
+}
+
+//
+// returns the ids linked with an object in either direction
+//
+func linkedWith(it *badger.Iterator, id string) []string {
+
+	ids := make([]string, 0)
+	linkoutPrefix := []byte(fmt.Sprintf("spol|%s|references|", id)) // objects we link to
+	for it.Seek(linkoutPrefix); it.ValidForPrefix(linkoutPrefix); it.Next() {
+		ids = append(ids, string(it.Item().Key()[len(linkoutPrefix):]))
+	}
+	linkinPrefix := []byte(fmt.Sprintf("opsl|%s|references|", id)) // things that link to this object
+	for it.Seek(linkinPrefix); it.ValidForPrefix(linkinPrefix); it.Next() {
+		ids = append(ids, string(it.Item().Key()[len(linkinPrefix):]))
+	}
+	return ids
+
+}
+
+//
+// reports whether the id is a Property.Link or Unique.Link
+// node rather than an object
+//
+func isLinkNode(it *badger.Iterator, id string) bool {
+
+	for _, typename := range []string{"Property.Link", "Unique.Link"} {
+		key := []byte(fmt.Sprintf("spo|%s|is-a|%s", id, typename))
+		it.Seek(key)
+		if it.Valid() && string(it.Item().Key()) == string(key) {
+			return true
+		}
+	}
+	return false
+
+}
+
+//
+// orders suggestions by score, highest first
+//
+func sortSuggestions(s []MatchSuggestion) {
+	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].Score != s[j].Score {
+			return s[i].Score > s[j].Score
+		}
+		if s[i].From != s[j].From {
+			return s[i].From < s[j].From
+		}
+		return s[i].To < s[j].To
+	})
+}
+
+//
+// the comparers for match fields, each returns
+// a similarity between 0 and 1
+//
+var comparers = map[string]func(a, b string) float64{
+	compareName:  compareNames,
+	compareEmail: compareEmails,
+	compareDate:  compareDates,
+	compareText: func(a, b string) float64 {
+		return jaroWinkler(strings.ToLower(strings.TrimSpace(a)), strings.ToLower(strings.TrimSpace(b)))
+	},
+	compareExact: compareExactly,
+}
+
+//
+// values must be equal, ignoring case
+// and surrounding space
+//
+func compareExactly(a, b string) float64 {
+	if strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b)) {
+		return 1
+	}
+	return 0
+}
+
+//
+// compares names as their words in sorted order,
+// so "Smith, Jane" matches "jane smith"
+//
+func compareNames(a, b string) float64 {
+
+	words := func(s string) string {
+		w := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		sort.Strings(w)
+		return strings.Join(w, " ")
+	}
+	return jaroWinkler(words(a), words(b))
+
+}
+
+//
+// compares email addresses, a different
+// domain counts against the match
+//
+func compareEmails(a, b string) float64 {
+
+	a = strings.ToLower(stripMailto(strings.TrimSpace(a)))
+	b = strings.ToLower(stripMailto(strings.TrimSpace(b)))
+	if a == b {
+		return 1
+	}
+	split := func(s string) (string, string) {
+		if i := strings.LastIndex(s, "@"); i >= 0 {
+			return s[:i], s[i+1:]
+		}
+		return s, ""
+	}
+	localA, domainA := split(a)
+	localB, domainB := split(b)
+	score := jaroWinkler(localA, localB)
+	if domainA != domainB {
+		score *= 0.8
+	}
+	return score
+
+}
+
+//
+// the date formats understood by the date comparer
+//
+var matchDateFormats = []string{
+	"2006-01-02",
+	"2006-01-02T15:04:05Z07:00",
+	"2006/01/02",
+	"20060102",
+	"02/01/2006",
+	"2/1/2006",
+	"02-01-2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+//
+// compares dates, allowing for a day and month transposed
+// or a single part of the date mistyped
+//
+func compareDates(a, b string) float64 {
+
+	da, okA := parseMatchDate(a)
+	db, okB := parseMatchDate(b)
+	if !okA || !okB {
+		return compareExactly(a, b)
+	}
+	if da.Equal(db) {
+		return 1
+	}
+	if da.Year() == db.Year() && int(da.Month()) == db.Day() && da.Day() == int(db.Month()) {
+		return 0.8
+	}
+	same := 0
+	if da.Year() == db.Year() {
+		same++
+	}
+	if da.Month() == db.Month() {
+		same++
+	}
+	if da.Day() == db.Day() {
+		same++
+	}
+	if same == 2 {
+		return 0.5
+	}
+	return 0
+
+}
+
+func parseMatchDate(s string) (time.Time, bool) {
+
+	s = strings.TrimSpace(s)
+	for _, layout := range matchDateFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			y, m, d := t.Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), true
+		}
+	}
+	return time.Time{}, false
+
+}
+
+//
+// Jaro-Winkler similarity of two strings, 1 if they are
+// equal and 0 if they have nothing in common
+//
+func jaroWinkler(a, b string) float64 {
+
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	window := len(ra)
+	if len(rb) > window {
+		window = len(rb)
+	}
+	window = window/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	matchedA := make([]bool, len(ra))
+	matchedB := make([]bool, len(rb))
+	matches := 0
+	for i := range ra {
+		lo, hi := i-window, i+window+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(rb) {
+			hi = len(rb)
+		}
+		for j := lo; j < hi; j++ {
+			if matchedB[j] || ra[i] != rb[j] {
+				continue
+			}
+			matchedA[i], matchedB[j] = true, true
+			matches++
+			break
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	j := 0
+	for i := range ra {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < len(ra) && prefix < len(rb) && prefix < 4 && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+
+}
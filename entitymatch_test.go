@@ -0,0 +1,126 @@
+// entitymatch_test.go
+
+package deep6
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestJaroWinkler(t *testing.T) {
+
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"MARTHA", "", 0},
+		{"MARTHA", "MARTHA", 1},
+		{"MARTHA", "MARHTA", 0.9611},
+		{"DWAYNE", "DUANE", 0.84},
+		{"DIXON", "DICKSONX", 0.8133},
+		{"CRATE", "TRACE", 0.7333},
+		{"ABC", "XYZ", 0},
+		{"José", "Jose", 0.8833},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			got := jaroWinkler(tt.a, tt.b)
+			if math.Abs(got-tt.want) > 0.0001 {
+				t.Errorf("jaroWinkler(%q, %q) = %.4f, want %.4f", tt.a, tt.b, got, tt.want)
+			}
+			// the measure is symmetric
+			if back := jaroWinkler(tt.b, tt.a); math.Abs(back-got) > 1e-9 {
+				t.Errorf("jaroWinkler(%q, %q) = %.4f, not %.4f", tt.b, tt.a, back, got)
+			}
+		})
+	}
+
+}
+
+func TestCompareDates(t *testing.T) {
+
+	tests := []struct {
+		name string
+		a, b string
+		want float64
+	}{
+		{"same date", "2010-03-04", "2010-03-04", 1},
+		{"same date, other formats", "2010-03-04", "04/03/2010", 1},
+		{"same date, written out", "4 March 2010", "20100304", 1},
+		{"same date, with a time", "2010-03-04T10:30:00Z", "2010/03/04", 1},
+		{"day and month transposed", "2010-03-04", "2010-04-03", 0.8},
+		{"year mistyped", "2010-03-04", "2011-03-04", 0.5},
+		{"day mistyped", "2010-03-04", "2010-03-14", 0.5},
+		{"two parts differ", "2010-03-04", "2011-05-04", 0},
+		{"different dates", "2010-03-04", "1999-12-25", 0},
+		{"not dates, same text", "unknown", "UNKNOWN", 1},
+		{"not dates, other text", "unknown", "n/a", 0},
+		{"one not a date", "unknown", "2010-03-04", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareDates(tt.a, tt.b); got != tt.want {
+				t.Errorf("compareDates(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestSuggestMatchesLeavesOutLinked(t *testing.T) {
+
+	d6 := openTestDeep6(t)
+	triples := make([]Triple, 0)
+	for _, id := range []string{"A", "B", "C", "D"} {
+		triples = append(triples,
+			Triple{S: id, P: "is-a", O: "Person"},
+			Triple{S: id, P: "Person.Name", O: "Jane Smith"})
+	}
+	triples = append(triples, Triple{S: "X1", P: "is-a", O: "Property.Link"})
+	storeTriples(t, d6.db, triples...)
+
+	// A links directly to B, C and D both link
+	// to the same Property.Link node
+	links := []Triple{
+		{S: "A", P: "references", O: "B"},
+		{S: "C", P: "references", O: "X1"},
+		{S: "D", P: "references", O: "X1"},
+	}
+	err := d6.db.Update(func(txn *badger.Txn) error {
+		for _, l := range links {
+			for _, k := range l.SextupleLink() {
+				if err := txn.Set([]byte(k), []byte{}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suggestions, err := d6.SuggestMatches(MatchSpec{
+		FromType: "Person",
+		ToType:   "Person",
+		Fields:   []MatchField{{From: []string{"Person.Name"}, To: []string{"Person.Name"}, Compare: compareName}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([][2]string, 0, len(suggestions))
+	for _, s := range suggestions {
+		got = append(got, [2]string{s.From, s.To})
+	}
+	want := [][2]string{{"A", "C"}, {"A", "D"}, {"B", "C"}, {"B", "D"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("suggested %v, want %v", got, want)
+	}
+
+}
@@ -27,7 +27,7 @@ func main() {
 
 	// load data from file if provided on command line
 	if len(*fname) > 0 {
-		err = db.IngestFromFile(*fname)
+		_, err = db.IngestFromFile(*fname)
 		if err != nil {
 			log.Println("error processing data file: ", err)
 		}
@@ -49,10 +49,13 @@ func main() {
 	}
 
 	for _, path := range sampleDataPaths {
-		err = db.IngestFromFile(path)
+		report, err := db.IngestFromFile(path)
 		if err != nil {
 			log.Println("error processing data file: ", err)
+			continue
 		}
+		log.Printf("%s: %d objects, %d triples, %d links", path,
+			report.ObjectsRead, report.TriplesWritten, report.LinksCreated)
 	}
 
 	var prettyJSON bytes.Buffer
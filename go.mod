@@ -6,9 +6,13 @@ require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/d4l3k/messagediff v1.2.1 // indirect
 	github.com/dgraph-io/badger v1.6.1
+	github.com/evanphx/json-patch v4.9.0+incompatible
+	github.com/klauspost/compress v1.11.13
 	github.com/nats-io/nuid v1.0.1
 	github.com/pkg/errors v0.9.1
 	github.com/tidwall/gjson v1.9.3
 	github.com/tidwall/sjson v1.1.1
 	github.com/tylertreat/BoomFilters v0.0.0-20200520150052-42a7b4300c0c
+	github.com/xeipuuv/gojsonschema v1.2.0
+	golang.org/x/text v0.3.3
 )
@@ -0,0 +1,289 @@
+// ingest_test.go
+
+package deep6
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+//
+// opens a d6 database with the default config in a
+// temporary folder, removed when the test ends
+//
+func openTestDeep6(t *testing.T) *Deep6DB {
+
+	t.Helper()
+	dir, err := ioutil.TempDir("", "d6-ingest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	d6, err := OpenFromFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d6.Close)
+	d6.AuditLevel = "none"
+	return d6
+
+}
+
+//
+// returns the ids of the objects the object references
+//
+func referencedBy(t *testing.T, db *badger.DB, id string) map[string]struct{} {
+
+	t.Helper()
+	refs := make(map[string]struct{})
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := []byte(fmt.Sprintf("spol|%s|references|", id))
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			refs[strings.TrimPrefix(string(it.Item().Key()), string(prefix))] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refs
+
+}
+
+func TestIngestRoundTrip(t *testing.T) {
+
+	d6 := openTestDeep6(t)
+
+	original := `{"StudentPersonal":{"RefId":"P1","LocalId":"L001","PersonInfo":{"Name":{"FamilyName":"Smith"}}}}`
+	reordered := `{"StudentPersonal":{"PersonInfo":{"Name":{"FamilyName":"Smith"}},"LocalId":"L001","RefId":"P1"}}`
+	updated := `{"StudentPersonal":{"RefId":"P1","LocalId":"L001","PersonInfo":{"Name":{"FamilyName":"Jones"}}}}`
+
+	steps := []struct {
+		name          string
+		data          string
+		wantReplaced  int
+		wantUnchanged int
+		wantWrites    bool // triples written
+		wantRemoves   bool // triples removed
+		wantFamily    string
+	}{
+		{"first ingest", original, 0, 0, true, false, "Smith"},
+		{"same data skipped", original, 0, 1, false, false, "Smith"},
+		{"key order ignored", reordered, 0, 1, false, false, "Smith"},
+		{"update replaces", updated, 1, 0, true, true, "Jones"},
+		{"update skipped once stored", updated, 0, 1, false, false, "Jones"},
+		{"original replaces update", original, 1, 0, true, true, "Smith"},
+	}
+
+	for _, s := range steps {
+		report, err := d6.IngestFromReader(strings.NewReader("[" + s.data + "]"))
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if report.ObjectsRead != 1 {
+			t.Errorf("%s: read %d objects, want 1", s.name, report.ObjectsRead)
+		}
+		if report.ObjectsReplaced != s.wantReplaced {
+			t.Errorf("%s: replaced %d objects, want %d", s.name, report.ObjectsReplaced, s.wantReplaced)
+		}
+		if report.ObjectsUnchanged != s.wantUnchanged {
+			t.Errorf("%s: %d objects unchanged, want %d", s.name, report.ObjectsUnchanged, s.wantUnchanged)
+		}
+		if (report.TriplesWritten > 0) != s.wantWrites {
+			t.Errorf("%s: wrote %d triples", s.name, report.TriplesWritten)
+		}
+		if (report.TriplesRemoved > 0) != s.wantRemoves {
+			t.Errorf("%s: removed %d triples", s.name, report.TriplesRemoved)
+		}
+
+		m, err := findById("P1", d6.db)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		sp, _ := m["StudentPersonal"].(map[string]interface{})
+		pi, _ := sp["PersonInfo"].(map[string]interface{})
+		name, _ := pi["Name"].(map[string]interface{})
+		if name["FamilyName"] != s.wantFamily {
+			t.Errorf("%s: stored FamilyName %v, want %s", s.name, name["FamilyName"], s.wantFamily)
+		}
+		if sp["LocalId"] != "L001" {
+			t.Errorf("%s: stored LocalId %v, want L001", s.name, sp["LocalId"])
+		}
+	}
+
+	// the replaced value leaves nothing behind to be found
+	results, err := d6.FindByValue("Jones", FilterSpec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("found %v by a replaced value", results)
+	}
+
+}
+
+func TestIngestLinksReferences(t *testing.T) {
+
+	school := `{"SchoolInfo":{"RefId":"S1","LocalId":"SCH01","SchoolName":"Hillview"}}`
+	course := `{"SchoolCourseInfo":{"RefId":"C1","SchoolInfoRefId":"S1","CourseCode":"MA01"}}`
+	student := `{"StudentPersonal":{"RefId":"P1","LocalId":"L001"}}`
+	list := `{"StudentAttendanceTimeList":{"RefId":"A1","StudentPersonalRefId":"P1","SchoolInfoRefId":"S1"}}`
+	enrolment := `{"StudentSchoolEnrollment":{"RefId":"E1","StudentLocalId":"L001"}}`
+
+	tests := []struct {
+		name    string
+		ingests []string // ingested one after another
+		id      string
+		want    []string // ids the object references
+	}{
+		{"forward reference", []string{school, course}, "C1", []string{"S1"}},
+		{"reference before its target", []string{course, school}, "C1", []string{"S1"}},
+		{"references to several objects", []string{school, student, list}, "A1", []string{"P1", "S1"}},
+		{"local id reference", []string{student, enrolment}, "E1", []string{"L001", "P1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d6 := openTestDeep6(t)
+			for _, data := range tt.ingests {
+				if _, err := d6.IngestFromReader(strings.NewReader("[" + data + "]")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := valueSet(tt.want...)
+			if got := referencedBy(t, d6.db, tt.id); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s references %v, want %v", tt.id, got, want)
+			}
+		})
+	}
+
+}
+
+//
+// counts the references between objects of each pair of types
+//
+func referenceCounts(t *testing.T, db *badger.DB) map[string]int {
+
+	t.Helper()
+	types := make(map[string]string)
+	counts := make(map[string]int)
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := []byte("pso|is-a|")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			tr := NewTriple(string(it.Item().Key()))
+			types[tr.S] = tr.O
+		}
+		prefix = []byte("psol|references|")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			tr := NewTriple(string(it.Item().Key()))
+			counts[types[tr.S]+" -> "+types[tr.O]]++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counts
+
+}
+
+func TestIngestSampleLinks(t *testing.T) {
+
+	// objects only see those earlier in the file once the ingest
+	// writer has committed them, so writing more (e.g. the labelled
+	// edges) must not change the links made, in particular the
+	// attendance lists of a school must not all link to each other
+	want := map[string]int{
+		"GradingAssignment -> GradingAssignment":       134,
+		"GradingAssignment -> Property.Link":           120,
+		"GradingAssignment -> TeachingGroup":           160,
+		"SchoolCourseInfo -> SchoolInfo":               8,
+		"SchoolInfo -> Property.Link":                  1,
+		"StaffPersonal -> Property.Link":               5,
+		"StudentAttendanceTimeList -> SchoolInfo":      430,
+		"StudentAttendanceTimeList -> StudentPersonal": 430,
+		"StudentPersonal -> Property.Link":             80,
+		"TeachingGroup -> Property.Link":               704,
+		"TeachingGroup -> StaffPersonal":               32,
+		"TeachingGroup -> StudentPersonal":             640,
+		"TeachingGroup -> TimeTableSubject":            32,
+		"TimeTableSubject -> Property.Link":            16,
+		"TimeTableSubject -> SchoolCourseInfo":         16,
+	}
+
+	d6 := openTestDeep6(t)
+	f, err := os.Open("example/d6/sample_data/sif/sif.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := d6.IngestFromReader(f); err != nil {
+		t.Fatal(err)
+	}
+
+	got := referenceCounts(t, d6.db)
+	for pair, n := range want {
+		if got[pair] != n {
+			t.Errorf("%s: %d references, want %d", pair, got[pair], n)
+		}
+	}
+	for pair, n := range got {
+		if _, ok := want[pair]; !ok {
+			t.Errorf("%s: %d unexpected references", pair, n)
+		}
+	}
+
+}
+
+func TestIngestObjectsRead(t *testing.T) {
+
+	objects := `[{"SchoolInfo":{"RefId":"S1"}},{"SchoolInfo":{"RefId":"S2"}}]`
+	collection := `[{"SchoolInfos":{"SchoolInfo":[{"RefId":"S1"},{"RefId":"S2"},{"RefId":"S3"}]}}]`
+
+	withoutClassifier := NewPipeline()
+	if err := withoutClassifier.Replace(StageObjectClassifier, TransformStage("drop-all", func(igd IngestData) (IngestData, bool, error) {
+		return igd, false, nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		pipeline *Pipeline
+		data     string
+		want     int
+	}{
+		{"objects", nil, objects, 2},
+		{"collection counts once", nil, collection, 1},
+		{"classifier replaced", withoutClassifier, objects, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d6 := openTestDeep6(t)
+			d6.Pipeline = tt.pipeline
+			report, err := d6.IngestFromReader(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.ObjectsRead != tt.want {
+				t.Errorf("read %d objects, want %d", report.ObjectsRead, tt.want)
+			}
+		})
+	}
+
+}
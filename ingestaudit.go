@@ -8,7 +8,8 @@ import (
 )
 
 //
-// terminator sink for ingest pipeline.
+// optional audit stage for ingest pipeline, only included
+// in the pipeline if an audit level other than none is set.
 // will write audit information based on auditLevel
 // useful for debugging; fast if piped to file, slower if
 // printing to console.
@@ -20,19 +21,19 @@ import (
 //           high - classification, original raw data, link candidates, link triples
 // in - channel providing IngestData objects
 //
-func ingestAuditSink(ctx context.Context, auditLevel string, in <-chan IngestData) (
+func ingestAudit(ctx context.Context, auditLevel string, in <-chan IngestData) (
+	<-chan IngestData, // pass data on to next stage
 	<-chan error, // emits errors encountered to the pipeline
 	error) { // any error encountered when creating this component
 
+	out := make(chan IngestData)
 	errc := make(chan error, 1)
 
 	go func() {
+		defer close(out)
 		defer close(errc)
 
 		for igd := range in {
-			if auditLevel == "none" {
-				continue
-			}
 
 			fmt.Println("=====")
 			fmt.Println("object id: ", igd.N3id)
@@ -48,6 +49,9 @@ func ingestAuditSink(ctx context.Context, auditLevel string, in <-chan IngestDat
 			if auditLevel == "basic" || auditLevel == "high" {
 				fmt.Println()
 				fmt.Println("link-specs: ", igd.LinkSpecs)
+				for _, e := range igd.SchemaErrors {
+					fmt.Println("schema error: ", e)
+				}
 			}
 
 			if auditLevel == "high" {
@@ -68,8 +72,13 @@ func ingestAuditSink(ctx context.Context, auditLevel string, in <-chan IngestDat
 
 			fmt.Println("=====")
 
+			select {
+			case out <- igd: // pass the data on to the next stage
+			case <-ctx.Done(): // listen for pipeline shutdown
+				return
+			}
 		}
 	}()
 
-	return errc, nil
+	return out, errc, nil
 }
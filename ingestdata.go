@@ -2,6 +2,10 @@
 
 package deep6
 
+import (
+	"github.com/xeipuuv/gojsonschema"
+)
+
 //
 // strucuture used by the ingest pipeeline to
 // pass original data and derived data between
@@ -14,6 +18,9 @@ package deep6
 // for more details.
 //
 type IngestData struct {
+	// Position of the object in the source stream
+	// (0 based), used to identify bad objects
+	Position int
 	// Unique id for the object being processed
 	// will be derived from the inbound json object
 	// or created by the pipeline
@@ -29,17 +36,26 @@ type IngestData struct {
 	// datatype.toml classification
 	// if no model can be derived will default to JSON
 	DataModel string
+	// Other data models whose classifiers also
+	// matched the object, in priority order
+	AlsoMatched []string
 	// The unmarshaled json of the object
 	// as a map[string]interface{}
 	RawData map[string]interface{}
 	// The specifications for which features of an object should
 	// be surfaced as links within the graph.
 	// Provided in ./config/datatype.toml.
-	// Searches triple predicates for the spec, so use e.g.
-	// ".RefId" to find the precise refid of a SIF object
-	// but use "RefId" (no dot) to find refids of referenced objects
-	// such as SchoolInfoRefId
+	// Each spec is a path matched exactly against triple
+	// predicates, with '#' and '*' as wildcard segments,
+	// e.g. "*.RefId" finds the refid of a SIF object
+	// (see linkspec.go)
 	LinkSpecs []string
+	// Whether link specs can also match the end
+	// of a predicate rather than the whole of it
+	LinkSuffixes bool
+	// Normalisers applied to the values of each link spec,
+	// keyed by link spec
+	Normalise map[string][]string
 	// Array of  values extracted during classification
 	// which will be concatenated to make
 	// a unique property identifier for objects
@@ -62,8 +78,63 @@ type IngestData struct {
 	// The slice of hexastore triples parsed
 	// from the original inbound data object
 	Triples []Triple
+	// Ids of objects this object must be linked to whether or
+	// not they match its link specs, e.g. the statement an
+	// xAPI StatementRef refers to
+	ExplicitLinks []string
 	// The set of generated triiples that link
 	// the features requested in LinkSpecs to the
 	// rest of the graph
 	LinkTriples []Triple
+	// Labelled edges written alongside the link
+	// triples, giving the reasons for each link
+	EdgeTriples []Triple
+	// The triples, outbound links and labelled edges of the
+	// stored version of the object, if there is one
+	StoredTriples []Triple
+	StoredLinks   []Triple
+	StoredEdges   []Triple
+	// The triples written and removed when the object
+	// was stored, for an update these are just the differences
+	// between the stored and inbound versions
+	AddedTriples   []Triple
+	RemovedTriples []Triple
+	// Set if the stored version of the object is incomplete,
+	// so all of its links are rebuilt rather than just those
+	// of the values that changed, and any it made before that
+	// are not rebuilt are removed
+	Relink bool
+	// Stored links, and their labelled edges, no longer
+	// supported by the data of an updated object
+	StaleLinks []Triple
+	StaleEdges []Triple
+	// Set if this object replaced an existing
+	// version of the object in the graph
+	Replaced bool
+	// Hash of the canonical content of the object
+	// stored once the object is fully written, and used to
+	// detect unchanged objects on re-ingest
+	ContentHash string
+	// Set if the object is identical to the stored
+	// version, later stages pass it on untouched
+	Unchanged bool
+	// The values for which Property.Link nodes
+	// were created when linking this object
+	PropertyLinks []string
+	// Link values of this object found to be
+	// hubs, which are no longer used to link
+	Hubs []Hub
+	// What to do if the object fails validation against
+	// the schema of its classifier, and the errors found
+	// if it did (see schemavalidator.go)
+	OnInvalid    string
+	SchemaErrors []string
+
+	schema *gojsonschema.Schema
+	// whether the n3id was derived from the content
+	// of the object (the hash n3id strategy)
+	contentId bool
+	// arrival order of the object in a parallel stage,
+	// used to put its output back in order
+	seq int
 }
@@ -0,0 +1,175 @@
+// ingestdirectory.go
+
+package deep6
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//
+// Outcome of ingesting a single file as part of
+// a directory ingest.
+//
+type FileIngestResult struct {
+	// path of the file ingested
+	Path string
+	// any error encountered ingesting this file,
+	// nil if the file loaded successfully
+	Err error
+	// report of the objects, triples and links
+	// ingested from this file
+	Report *IngestReport
+	// time taken to ingest the file
+	Elapsed time.Duration
+}
+
+//
+// Load all files in a directory tree that match the
+// given pattern into D6.
+//
+// Files are ingested concurrently by a bounded pool of workers,
+// a file that fails to load is reported in its result and
+// does not stop the remaining files from being ingested.
+//
+// Each file is written with its own writer, flushed as soon as
+// the file is done, so objects in later files link directly to
+// those in files already loaded; files loaded at the same time
+// link through their shared values, as objects in the same file do.
+//
+// path - root of the directory tree to walk, e.g. ./sample_data
+// pattern - glob (as filepath.Match) applied to file names e.g. "*.json",
+// if the pattern contains a path separator it is matched against
+// the path relative to the root instead, e.g. "sif/*.xml".
+// An empty pattern matches all files.
+// workers - max number of files ingested at the same time,
+// if < 1 defaults to the number of cpus.
+//
+// Returns one result per matched file in walk order, and a report
+// combining the results of all files; error is only returned
+// if the directory tree cannot be read.
+//
+func (d6 *Deep6DB) IngestFromDirectory(path, pattern string, workers int) ([]FileIngestResult, *IngestReport, error) {
+	return d6.IngestFromDirectoryContext(context.Background(), path, pattern, workers)
+}
+
+//
+// Load all files in a directory tree that match the given pattern,
+// stopping if ctx is cancelled or its deadline passes, see
+// IngestFromDirectory() and IngestFromReaderContext().
+//
+// If the ingest stops early the files being loaded stop part way,
+// files not yet started are not loaded, their results carry the ctx
+// error, and the ctx error is returned.
+//
+func (d6 *Deep6DB) IngestFromDirectoryContext(ctx context.Context, path, pattern string, workers int) ([]FileIngestResult, *IngestReport, error) {
+
+	defer timeTrack(time.Now(), "IngestFromDirectory() "+path)
+
+	if pattern == "" {
+		pattern = "*"
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid file pattern: "+pattern)
+	}
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	//
+	// find the files to load
+	//
+	files := make([]string, 0)
+	err := filepath.Walk(path, func(fname string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		target := info.Name()
+		if strings.ContainsRune(pattern, filepath.Separator) {
+			target, err = filepath.Rel(path, fname)
+			if err != nil {
+				return err
+			}
+		}
+		if ok, _ := filepath.Match(pattern, target); ok {
+			files = append(files, fname)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot read directory: "+path)
+	}
+
+	//
+	// ingest them with a bounded pool of workers
+	//
+	report := newIngestReport()
+	defer report.finish()
+	results := make([]FileIngestResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = d6.ingestDirectoryFile(ctx, files[i])
+				report.merge(results[i].Report)
+			}
+		}()
+	}
+	for i := range files {
+		if ctx.Err() != nil {
+			results[i] = FileIngestResult{Path: files[i], Err: ctx.Err(), Report: newIngestReport()}
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return results, report, errors.Wrap(ctx.Err(), "directory ingest stopped:")
+	}
+	return results, report, nil
+
+}
+
+//
+// ingests one file of a directory load, flushing
+// its writes once the file is done
+//
+func (d6 *Deep6DB) ingestDirectoryFile(ctx context.Context, fname string) FileIngestResult {
+
+	result := FileIngestResult{Path: fname, Report: newIngestReport()}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		result.Err = errors.Wrap(err, "cannot open data file: ")
+	} else {
+		wb := d6.db.NewWriteBatch()
+		err = d6.ingestStream(ctx, f, wb, result.Report)
+		if err != nil {
+			result.Err = errors.Wrap(err, "error ingesting data from file:")
+		}
+		if err := wb.Flush(); err != nil && result.Err == nil {
+			result.Err = errors.Wrap(err, "cannot write data from file:")
+		}
+		f.Close()
+	}
+	result.Report.finish()
+	result.Elapsed = result.Report.Elapsed
+
+	return result
+
+}
@@ -3,55 +3,148 @@
 package deep6
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
 )
 
 //
 // Load data into D6 from a file
+// gzip or zstd compressed files are decompressed as they are read.
 //
-func (d6 *Deep6DB) IngestFromFile(fname string) error {
+// Returns a report of the objects, triples and links ingested.
+//
+func (d6 *Deep6DB) IngestFromFile(fname string) (*IngestReport, error) {
+	return d6.IngestFromFileContext(context.Background(), fname, IngestOptions{})
+}
+
+//
+// Load data into D6 from a file, stopping if ctx is cancelled
+// or its deadline passes, see IngestFromReaderContext().
+//
+func (d6 *Deep6DB) IngestFromFileContext(ctx context.Context, fname string, opts IngestOptions) (*IngestReport, error) {
 
 	defer timeTrack(time.Now(), "IngestFromFile() "+fname)
 
 	// open the data file
 	f, err := os.Open(fname)
 	if err != nil {
-		return errors.Wrap(err, "cannot open data file: ")
+		return nil, errors.Wrap(err, "cannot open data file: ")
 	}
+	defer f.Close()
 
-	return d6.IngestFromReader(f)
+	return d6.IngestFromReaderContext(ctx, f, opts)
 
 }
 
 //
 // Load data into D6 from an http request
+// honours the Content-Encoding of the request body (gzip, zstd),
+// and will also detect compressed bodies sent without one.
+//
+// The ingest stops if the request's context is cancelled,
+// e.g. the client disconnects, see IngestFromReaderContext().
 //
-func (d6 *Deep6DB) IngestFromHTTPRequest(r *http.Request) error {
+// Returns a report of the objects, triples and links ingested.
+//
+func (d6 *Deep6DB) IngestFromHTTPRequest(r *http.Request) (*IngestReport, error) {
+
+	body, err := decodeContentEncoding(r.Body, r.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read request body:")
+	}
+	defer body.Close()
+
+	return d6.IngestFromReaderContext(r.Context(), body, IngestOptions{})
 
-	return d6.IngestFromReader(r.Body)
+}
 
+//
+// Options for the context-aware ingest methods.
+//
+type IngestOptions struct {
+	// if set, called with the progress of the ingest as
+	// objects complete the pipeline, and once more when
+	// the ingest ends; called from the pipeline so should
+	// return quickly
+	Progress func(IngestProgress)
+	// minimum time between progress calls,
+	// 0 calls Progress for every object
+	ProgressInterval time.Duration
 }
 
 //
 // Feed data in D6 from any io.Reader
+// gzip or zstd compressed streams are decompressed as they are read.
+//
+// Returns a report of the objects, triples and links ingested,
+// if an error occurs the report covers the data ingested
+// before the error.
 //
-func (d6 *Deep6DB) IngestFromReader(r io.Reader) error {
+func (d6 *Deep6DB) IngestFromReader(r io.Reader) (*IngestReport, error) {
+	return d6.IngestFromReaderContext(context.Background(), r, IngestOptions{})
+}
 
-	err := runIngestWithReader(d6.db, d6.iwb, d6.sbf, r, d6.AuditLevel, d6.folderPath)
-	if err != nil {
-		return errors.Wrap(err, "error ingesting data from reader:")
-	}
+//
+// Feed data in D6 from any io.Reader, stopping if ctx
+// is cancelled or its deadline passes.
+//
+// If the ingest stops early the error returned is
+// the ctx error, and the write batch is flushed so that every
+// object counted in the report is fully stored. Objects that were
+// part-way through the pipeline may be partly written, but
+// are only marked as stored once complete (see contentHash()),
+// and an update removes the mark of the version it replaces
+// before writing, so ingesting the same data again completes
+// them, rebuilding all of their links. If the write batch
+// cannot be flushed its error is returned, as the objects in
+// the report may then not be stored.
+//
+func (d6 *Deep6DB) IngestFromReaderContext(ctx context.Context, r io.Reader, opts IngestOptions) (*IngestReport, error) {
+
+	report := newIngestReport()
+	defer report.finish()
+	report.progress = opts.Progress
+	report.progressEvery = opts.ProgressInterval
+
+	err := d6.ingestStream(ctx, r, d6.iwb, report)
 	// ensure the writer finishes
-	d6.iwb.Flush()
+	flushErr := d6.iwb.Flush()
 	// reinstate the writer
 	d6.iwb = d6.db.NewWriteBatch()
+	report.reportProgress(true)
+	if err != nil {
+		return report, errors.Wrap(err, "error ingesting data from reader:")
+	}
+	if flushErr != nil {
+		return report, errors.Wrap(flushErr, "error writing data from reader:")
+	}
+
+	return report, nil
+
+}
+
+//
+// runs the ingest pipeline over a (possibly compressed) stream
+// without flushing the writer, which the caller flushes once
+// the stream is done.
+//
+// wb - the writer for the stream's triples and links
+//
+func (d6 *Deep6DB) ingestStream(ctx context.Context, r io.Reader, wb *badger.WriteBatch, report *IngestReport) error {
+
+	dr, err := decompressReader(&countingReader{r: r, report: report})
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
 
-	return err
+	return runIngestWithReader(ctx, d6.db, wb, d6.sbf, dr, d6.AuditLevel, d6.Classifiers, d6.pipeline(), d6.IngestWorkers, report, d6.deadLetterQueue(report))
 
 }
 
@@ -60,17 +153,38 @@ func (d6 *Deep6DB) IngestFromReader(r io.Reader) error {
 // byte slices - typically for us the iterator from crdt manager
 // receiver
 //
-func (d6 *Deep6DB) IngestFromJSONChannel(c <-chan []byte) error {
+// Returns a report of the objects, triples and links ingested.
+//
+func (d6 *Deep6DB) IngestFromJSONChannel(c <-chan []byte) (*IngestReport, error) {
+	return d6.IngestFromJSONChannelContext(context.Background(), c, IngestOptions{})
+}
 
-	err := runIngestWithIterator(d6.db, d6.iwb, d6.sbf, c, d6.AuditLevel, d6.folderPath)
-	if err != nil {
-		return errors.Wrap(err, "error ingesting data from channel reader:")
-	}
+//
+// Feed data into db from a channel of json objects, stopping if ctx
+// is cancelled or its deadline passes, see IngestFromReaderContext().
+//
+// The channel is not drained if the ingest stops early.
+//
+func (d6 *Deep6DB) IngestFromJSONChannelContext(ctx context.Context, c <-chan []byte, opts IngestOptions) (*IngestReport, error) {
+
+	report := newIngestReport()
+	defer report.finish()
+	report.progress = opts.Progress
+	report.progressEvery = opts.ProgressInterval
+
+	err := runIngestWithIterator(ctx, d6.db, d6.iwb, d6.sbf, c, d6.AuditLevel, d6.Classifiers, d6.pipeline(), d6.IngestWorkers, report, d6.deadLetterQueue(report))
 	// ensure the writer finishes
-	d6.iwb.Flush()
+	flushErr := d6.iwb.Flush()
 	// reinstate the writer
 	d6.iwb = d6.db.NewWriteBatch()
+	report.reportProgress(true)
+	if err != nil {
+		return report, errors.Wrap(err, "error ingesting data from channel reader:")
+	}
+	if flushErr != nil {
+		return report, errors.Wrap(flushErr, "error writing data from channel reader:")
+	}
 
-	return err
+	return report, nil
 
 }
@@ -0,0 +1,471 @@
+// ingestreport.go
+
+package deep6
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+//
+// Summary of the work done by an ingest,
+// returned by all of the Ingest...() methods.
+//
+type IngestReport struct {
+	// number of objects read from the source, a json
+	// collection envelope counts as one object
+	ObjectsRead int
+	// number of bytes read from the source stream,
+	// before any decompression
+	BytesRead int64
+	// number of objects rejected to the dead-letter
+	// queue by a tolerant ingest
+	DeadLetters int
+	// whether a tolerant ingest stopped reading the source
+	// part way, at data it cannot read past (malformed xml),
+	// the rest of the source is captured as a dead letter
+	Truncated bool
+	// number of objects classified, by data model
+	// and then by object type e.g. ["SIF"]["StudentPersonal"]
+	Classified map[string]map[string]int
+	// number of objects that matched more than one
+	// classifier, by the data model chosen and then
+	// by the other data model matched
+	// e.g. ["XAPI"]["Lesson"]
+	Ambiguous map[string]map[string]int
+	// number of objects that replaced an existing
+	// version of the object
+	ObjectsReplaced int
+	// number of objects skipped because they were
+	// identical to the version already stored
+	ObjectsUnchanged int
+	// number of object triples written, for updated
+	// objects only the triples that changed are written
+	TriplesWritten int
+	// number of triples removed from updated objects
+	TriplesRemoved int
+	// number of links created between objects
+	LinksCreated int
+	// number of Property.Link nodes created to
+	// hold links to values not (yet) owned by an object
+	PropertyLinksCreated int
+	// number of links removed from updated objects
+	LinksRemoved int
+	// link values flagged as hubs during the ingest, with the
+	// number of objects holding each (see Deep6DB.Hubs())
+	Hubs map[string]int
+	// number of objects that failed validation against
+	// the schema of their classifier, by data model
+	Invalid map[string]int
+	// the objects that failed validation and why, only the
+	// first maxValidationFailures are kept
+	ValidationFailures []ValidationFailure
+	// time each stage of the pipeline spent working on objects,
+	// not counting time waiting for objects to arrive or for the
+	// next stage to take them, summed over the lanes or workers of
+	// the stage; stages made with NewStage() are not timed
+	StageTimings map[string]time.Duration
+	// the n3ids of all objects ingested,
+	// in the order they completed the pipeline
+	N3ids []string
+	// total time taken by the ingest
+	Elapsed time.Duration
+
+	start   time.Time
+	mu      sync.Mutex
+	touched map[string]struct{}
+	clocks  map[string]*stageClock
+
+	processed     int
+	progress      func(IngestProgress)
+	progressEvery time.Duration
+	lastProgress  time.Time
+}
+
+//
+// An object that failed validation against
+// the schema of its classifier.
+//
+type ValidationFailure struct {
+	// position of the object in the source stream (0 based)
+	Position int
+	// classification of the object
+	N3id      string
+	DataModel string
+	// what was done with the object, one of
+	// reject, quarantine or annotate
+	Action string
+	// the validation errors
+	Errors []string
+}
+
+//
+// the most validation failures kept by a report, so a bad
+// schema cannot fill memory on a large ingest; all are counted
+//
+const maxValidationFailures = 1000
+
+//
+// Progress of an ingest, passed to the
+// IngestOptions.Progress callback.
+//
+type IngestProgress struct {
+	// number of objects read from the source, a json
+	// collection envelope counts as one object
+	ObjectsRead int
+	// number of objects that have completed the pipeline
+	// and are fully stored
+	ObjectsProcessed int
+	// number of bytes read from the source stream
+	BytesRead int64
+	// time since the ingest started
+	Elapsed time.Duration
+}
+
+//
+// create an empty report, timings are taken
+// from the moment the report is created.
+//
+func newIngestReport() *IngestReport {
+	return &IngestReport{
+		Classified:   make(map[string]map[string]int),
+		Ambiguous:    make(map[string]map[string]int),
+		Hubs:         make(map[string]int),
+		Invalid:      make(map[string]int),
+		StageTimings: make(map[string]time.Duration),
+		N3ids:        make([]string, 0),
+		start:        time.Now(),
+		touched:      make(map[string]struct{}),
+		clocks:       make(map[string]*stageClock),
+	}
+}
+
+//
+// marks the end of the ingest
+//
+func (ir *IngestReport) finish() {
+	ir.Elapsed = time.Since(ir.start)
+}
+
+//
+// adds the results of another report (e.g. from
+// a single file of a directory load) to this one.
+//
+func (ir *IngestReport) merge(other *IngestReport) {
+
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	other.mu.Lock()
+	defer other.mu.Unlock()
+
+	ir.ObjectsRead += other.ObjectsRead
+	ir.BytesRead += other.BytesRead
+	ir.DeadLetters += other.DeadLetters
+	ir.Truncated = ir.Truncated || other.Truncated
+	ir.ObjectsReplaced += other.ObjectsReplaced
+	ir.ObjectsUnchanged += other.ObjectsUnchanged
+	ir.TriplesWritten += other.TriplesWritten
+	ir.TriplesRemoved += other.TriplesRemoved
+	ir.LinksCreated += other.LinksCreated
+	ir.PropertyLinksCreated += other.PropertyLinksCreated
+	ir.LinksRemoved += other.LinksRemoved
+	for model, types := range other.Classified {
+		for objectType, n := range types {
+			ir.addClassified(model, objectType, n)
+		}
+	}
+	for model, others := range other.Ambiguous {
+		for otherModel, n := range others {
+			ir.addAmbiguous(model, otherModel, n)
+		}
+	}
+	for value, degree := range other.Hubs {
+		if degree > ir.Hubs[value] {
+			ir.Hubs[value] = degree
+		}
+	}
+	for model, n := range other.Invalid {
+		ir.Invalid[model] += n
+	}
+	for _, vf := range other.ValidationFailures {
+		if len(ir.ValidationFailures) < maxValidationFailures {
+			ir.ValidationFailures = append(ir.ValidationFailures, vf)
+		}
+	}
+	for stage, d := range other.StageTimings {
+		ir.StageTimings[stage] += d
+	}
+	for _, id := range other.N3ids {
+		ir.addN3id(id)
+	}
+
+}
+
+func (ir *IngestReport) addClassified(model, objectType string, n int) {
+	types, ok := ir.Classified[model]
+	if !ok {
+		types = make(map[string]int)
+		ir.Classified[model] = types
+	}
+	types[objectType] += n
+}
+
+func (ir *IngestReport) addAmbiguous(model, otherModel string, n int) {
+	others, ok := ir.Ambiguous[model]
+	if !ok {
+		others = make(map[string]int)
+		ir.Ambiguous[model] = others
+	}
+	others[otherModel] += n
+}
+
+//
+// records an object that failed validation,
+// safe to call on a nil report
+//
+func (ir *IngestReport) addInvalid(vf ValidationFailure) {
+
+	if ir == nil {
+		return
+	}
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	ir.Invalid[vf.DataModel]++
+	if len(ir.ValidationFailures) < maxValidationFailures {
+		ir.ValidationFailures = append(ir.ValidationFailures, vf)
+	}
+
+}
+
+func (ir *IngestReport) addN3id(id string) {
+	if _, ok := ir.touched[id]; ok {
+		return
+	}
+	ir.touched[id] = struct{}{}
+	ir.N3ids = append(ir.N3ids, id)
+}
+
+//
+// calls the progress callback, if there is one, at most once
+// per progress interval unless final is set.
+//
+func (ir *IngestReport) reportProgress(final bool) {
+
+	ir.mu.Lock()
+	if ir.progress == nil || (!final && time.Since(ir.lastProgress) < ir.progressEvery) {
+		ir.mu.Unlock()
+		return
+	}
+	ir.lastProgress = time.Now()
+	p := IngestProgress{
+		ObjectsRead:      ir.ObjectsRead,
+		ObjectsProcessed: ir.processed,
+		BytesRead:        ir.BytesRead,
+		Elapsed:          time.Since(ir.start),
+	}
+	progress := ir.progress
+	ir.mu.Unlock()
+
+	progress(p)
+
+}
+
+//
+// reader that counts the bytes read from
+// the source stream into the report.
+//
+type countingReader struct {
+	r      io.Reader
+	report *IngestReport
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.report.mu.Lock()
+	cr.report.BytesRead += int64(n)
+	cr.report.mu.Unlock()
+	return n, err
+}
+
+//
+// returns a channel that relays the stage's errors,
+// and records the busy time of the stage when the
+// stage closes its error channel.
+//
+// if a stage runs as several workers the time
+// of all of them is kept.
+//
+func (ir *IngestReport) watch(stage string, errc <-chan error) <-chan error {
+
+	out := make(chan error, 1)
+	go func() {
+		defer close(out)
+		for err := range errc {
+			out <- err
+		}
+		ir.mu.Lock()
+		if sc, ok := ir.clocks[stage]; ok {
+			if busy, used := sc.total(); used {
+				ir.StageTimings[stage] = busy
+			}
+		}
+		ir.mu.Unlock()
+	}()
+	return out
+
+}
+
+//
+// returns the context to start a stage with,
+// which carries the clock for its busy time.
+//
+func (ir *IngestReport) timeStage(ctx context.Context, stage string) context.Context {
+
+	ir.mu.Lock()
+	sc, ok := ir.clocks[stage]
+	if !ok {
+		sc = &stageClock{}
+		ir.clocks[stage] = sc
+	}
+	ir.mu.Unlock()
+	return context.WithValue(ctx, stageClockKey{}, sc)
+
+}
+
+//
+// wraps an ingest stage so that its busy
+// time is recorded in the report.
+//
+func (ir *IngestReport) timed(stage string, run ingestStage) ingestStage {
+	return func(ctx context.Context, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		out, errc, err := run(ir.timeStage(ctx, stage), in)
+		if err != nil {
+			return nil, nil, err
+		}
+		return out, ir.watch(stage, errc), nil
+	}
+}
+
+//
+// Adds up the time a stage spends working on objects, from
+// taking each object until it is ready to pass it on; shared
+// by all of the lanes or workers of the stage.
+//
+type stageClock struct {
+	mu   sync.Mutex
+	busy time.Duration
+	used bool
+}
+
+type stageClockKey struct{}
+
+//
+// returns the clock of the stage started with ctx,
+// nil if the stage is not being timed
+//
+func stageClockFrom(ctx context.Context) *stageClock {
+	sc, _ := ctx.Value(stageClockKey{}).(*stageClock)
+	return sc
+}
+
+//
+// adds the time since the stage took an object,
+// safe to call on a nil clock
+//
+func (sc *stageClock) add(start time.Time) {
+
+	if sc == nil {
+		return
+	}
+	d := time.Since(start)
+	sc.mu.Lock()
+	sc.busy += d
+	sc.used = true
+	sc.mu.Unlock()
+
+}
+
+//
+// returns the busy time so far, used is false
+// if the stage has not recorded any
+//
+func (sc *stageClock) total() (busy time.Duration, used bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.busy, sc.used
+}
+
+//
+// counts objects emitted by the source stage
+// as they pass on to the rest of the pipeline.
+//
+func (ir *IngestReport) countSource(ctx context.Context, in <-chan IngestData) <-chan IngestData {
+
+	out := make(chan IngestData)
+	go func() {
+		defer close(out)
+		for igd := range in {
+			ir.mu.Lock()
+			ir.ObjectsRead++
+			ir.mu.Unlock()
+			select {
+			case out <- igd: // pass the data onto the next stage
+			case <-ctx.Done(): // listen for pipeline shutdown
+				return
+			}
+		}
+	}()
+	return out
+
+}
+
+//
+// terminator sink for the ingest pipeline, collects the
+// outcome of each object into the report.
+//
+// ctx - pipeline mangement context
+// report - the report to update
+// in - channel providing IngestData objects
+//
+func ingestReportSink(ctx context.Context, report *IngestReport, in <-chan IngestData) (
+	<-chan error, // emits errors encountered to the pipeline
+	error) { // any error encountered when creating this component
+
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+
+		for igd := range in {
+			report.mu.Lock()
+			report.addClassified(igd.DataModel, igd.Type, 1)
+			for _, other := range igd.AlsoMatched {
+				report.addAmbiguous(igd.DataModel, other, 1)
+			}
+			if igd.Replaced {
+				report.ObjectsReplaced++
+			}
+			if igd.Unchanged {
+				report.ObjectsUnchanged++
+			}
+			report.TriplesWritten += len(igd.AddedTriples)
+			report.TriplesRemoved += len(igd.RemovedTriples)
+			report.LinksCreated += len(igd.LinkTriples)
+			report.LinksRemoved += len(igd.StaleLinks)
+			report.PropertyLinksCreated += len(igd.PropertyLinks)
+			for _, hub := range igd.Hubs {
+				if hub.Degree > report.Hubs[hub.Value] {
+					report.Hubs[hub.Value] = hub.Degree
+				}
+			}
+			report.addN3id(igd.N3id)
+			report.processed++
+			report.mu.Unlock()
+			report.reportProgress(false)
+		}
+	}()
+
+	return errc, nil
+}
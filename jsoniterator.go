@@ -18,31 +18,40 @@ import (
 //
 // ctx - required context for pipeline management
 // c - channel providing json objects as []bytes
+// dlq - dead-letter queue for objects that cannot be decoded,
+// if nil any bad object stops the pipeline
 //
-func jsonIteratorSource(ctx context.Context, c <-chan []byte) (
-	<-chan map[string]interface{}, // source emits json objects read from file as map
+func jsonIteratorSource(ctx context.Context, c <-chan []byte, dlq *deadLetterQueue) (
+	<-chan IngestData, // source emits json objects read from file as map in IngestData
 	<-chan error, // emits any errors encountered to the pipeline
 	error) { // any error when creating the source stage itself
 
-	out := make(chan map[string]interface{})
+	out := make(chan IngestData)
 	errc := make(chan error, 1)
 
 	go func() {
 		defer close(out)
 		defer close(errc)
 
+		position := 0
 		for jsonBytes := range c {
 			var m map[string]interface{}
 			if err := json.Unmarshal(jsonBytes, &m); err != nil {
-				errc <- errors.Wrap(err, "unable to unmarshal json jsonIteratorSource():")
-				return
+				err = errors.Wrap(err, "unable to unmarshal json jsonIteratorSource():")
+				if !dlq.reject(position, -1, jsonBytes, "json-iterator", err) {
+					errc <- err
+					return
+				}
+				position++
+				continue
 			}
 
 			select {
-			case out <- m: // pass the map onto the next stage
+			case out <- IngestData{RawData: m, Position: position}: // pass the map onto the next stage
 			case <-ctx.Done(): // listen for pipeline shutdown
 				return
 			}
+			position++
 		}
 
 	}()
@@ -3,9 +3,12 @@
 package deep6
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 
 	"github.com/pkg/errors"
 )
@@ -19,19 +22,26 @@ import (
 //
 // ctx - required context for pipeline management
 // r - reader accessing json data
+// dlq - dead-letter queue for objects that cannot be decoded,
+// if nil any bad object stops the pipeline
 //
-func jsonReaderSource(ctx context.Context, r io.Reader) (
-	<-chan map[string]interface{}, // source emits json objects read from file as map
+func jsonReaderSource(ctx context.Context, r io.Reader, dlq *deadLetterQueue) (
+	<-chan IngestData, // source emits json objects read from file as map in IngestData
 	<-chan error, // emits any errors encountered to the pipeline
 	error) { // any error when creating the source stage itself
 
-	out := make(chan map[string]interface{})
+	out := make(chan IngestData)
 	errc := make(chan error, 1)
 
 	go func() {
 		defer close(out)
 		defer close(errc)
 
+		if dlq != nil {
+			tolerantJSONRead(ctx, r, dlq, out, errc)
+			return
+		}
+
 		d := json.NewDecoder(r)
 
 		// read opening brace "["
@@ -42,7 +52,7 @@ func jsonReaderSource(ctx context.Context, r io.Reader) (
 		}
 
 		// read json objects one by one
-		for d.More() {
+		for position := 0; d.More(); position++ {
 
 			var m map[string]interface{}
 			err := d.Decode(&m)
@@ -52,7 +62,7 @@ func jsonReaderSource(ctx context.Context, r io.Reader) (
 			}
 
 			select {
-			case out <- m: // pass the map onto the next stage
+			case out <- IngestData{RawData: m, Position: position}: // pass the map onto the next stage
 			case <-ctx.Done(): // listen for pipeline shutdown
 				return
 			}
@@ -62,3 +72,219 @@ func jsonReaderSource(ctx context.Context, r io.Reader) (
 
 	return out, errc, nil
 }
+
+//
+// reads the json array in tolerant mode, sending each object on
+// and capturing any element that is not a valid object in the
+// dead-letter queue before carrying on with the next one
+//
+// Elements are found by the nesting of brackets alone (see
+// arrayScanner) so reading can resume after an element with
+// a syntax error. If the stream ends before the array is closed,
+// e.g. a truncated file, the remainder is captured and the ingest
+// fails, as the data cannot be trusted to be complete.
+//
+func tolerantJSONRead(ctx context.Context, r io.Reader, dlq *deadLetterQueue, out chan<- IngestData, errc chan<- error) {
+
+	s := newArrayScanner(r)
+
+	// read opening brace "["
+	if err := s.open(); err != nil {
+		err = errors.Wrap(err, "unexpected token; json file should be json array")
+		if !dlq.reject(0, s.offset, s.rest(), "json-reader", err) {
+			errc <- err
+		}
+		return
+	}
+
+	for position := 0; ; position++ {
+
+		raw, err := s.next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			err = errors.Wrap(err, "unable to decode json object.")
+			dlq.reject(position, s.offset, raw, "json-reader", err)
+			errc <- err
+			return
+		}
+
+		var m map[string]interface{}
+		if err := json.Unmarshal(raw, &m); err != nil {
+			err = errors.Wrap(err, "unable to decode json object.")
+			if !dlq.reject(position, s.offset, raw, "json-reader", err) {
+				errc <- err
+				return
+			}
+			continue
+		}
+
+		select {
+		case out <- IngestData{RawData: m, Position: position}: // pass the map onto the next stage
+		case <-ctx.Done(): // listen for pipeline shutdown
+			return
+		}
+
+	}
+
+}
+
+//
+// Splits a json array into the raw bytes of its elements, without
+// parsing them, so that an element that is not valid json can be
+// skipped.
+//
+// Element boundaries are found from the nesting of brackets outside
+// of strings; an element with unbalanced brackets or an unterminated
+// string runs on into the elements after it, so is only found to be
+// bad at the end of the stream.
+//
+type arrayScanner struct {
+	r *bufio.Reader
+	// bytes read from the stream
+	offset int64
+	// whether the closing ']' has been read
+	closed bool
+}
+
+func newArrayScanner(r io.Reader) *arrayScanner {
+	return &arrayScanner{r: bufio.NewReader(r)}
+}
+
+//
+// reads the opening '[' of the array
+//
+func (s *arrayScanner) open() error {
+
+	if bom, _ := s.r.Peek(3); string(bom) == "\xEF\xBB\xBF" { // utf-8 byte order mark
+		s.r.Discard(3)
+		s.offset += 3
+	}
+	b, err := s.skipSpace()
+	if err != nil {
+		return err
+	}
+	if b != '[' {
+		s.r.UnreadByte()
+		s.offset--
+		return fmt.Errorf("invalid character %q at offset %d", b, s.offset)
+	}
+	return nil
+
+}
+
+//
+// returns the raw bytes of the next element of the array, io.EOF
+// once the closing ']' is read, or io.ErrUnexpectedEOF along with
+// the bytes left if the stream ends first
+//
+func (s *arrayScanner) next() ([]byte, error) {
+
+	if s.closed {
+		return nil, io.EOF
+	}
+
+	// skip to the start of the element
+	var b byte
+	var err error
+	for {
+		b, err = s.skipSpace()
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if b == ']' {
+			s.closed = true
+			return nil, io.EOF
+		}
+		if b != ',' {
+			break
+		}
+	}
+
+	elem := []byte{b}
+	depth, inString, escaped := 0, b == '"', false
+	if b == '{' || b == '[' {
+		depth = 1
+	}
+	// a stray '}' is returned as it is, for the decoder to report
+	for depth > 0 || inString || isScalarByte(b) {
+		b, err = s.r.ReadByte()
+		if err != nil {
+			return elem, io.ErrUnexpectedEOF
+		}
+		s.offset++
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+		case b == '"':
+			inString = true
+		case b == '{' || b == '[':
+			depth++
+		case b == '}' || b == ']':
+			if depth == 0 {
+				// end of a scalar element, the array is closed
+				s.r.UnreadByte()
+				s.offset--
+				return elem, nil
+			}
+			depth--
+		case depth == 0 && (b == ',' || isSpace(b)):
+			// end of a scalar element
+			s.r.UnreadByte()
+			s.offset--
+			return elem, nil
+		}
+		elem = append(elem, b)
+		if depth == 0 && !inString && (b == '}' || b == ']') {
+			break
+		}
+	}
+	return elem, nil
+
+}
+
+//
+// returns the rest of the stream, used to capture
+// a stream that is not an array at all
+//
+func (s *arrayScanner) rest() []byte {
+	raw, _ := ioutil.ReadAll(s.r)
+	s.offset += int64(len(raw))
+	return raw
+}
+
+//
+// reads up to the next byte that is not white space
+//
+func (s *arrayScanner) skipSpace() (byte, error) {
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		s.offset++
+		if !isSpace(b) {
+			return b, nil
+		}
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+//
+// reports whether the byte can continue a scalar element
+// (number, true, false, null) outside of a string
+//
+func isScalarByte(b byte) bool {
+	return b != '{' && b != '[' && b != '"' && b != '}' && b != ']' && b != ',' && !isSpace(b)
+}
@@ -1,10 +1,13 @@
-// linkbuilder.go
+// linkReverseChecker.go
 
 package deep6
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
@@ -20,11 +23,17 @@ import (
 // refids or sif local-ids, but will be looking for particular
 // values (.Object) properties.
 //
+// Candidates whose value is on the stop-list, or is a hub held by
+// too many objects, are dropped here so they are never linked; new
+// hubs are flagged in the db (see linkhubs.go).
+//
 // ctx - pipeline management context
 // db - badger db used for lookups of objects to link to
+// wb - writer for hub flags, or a discardWriter for a dry run
+// rules - stop-list and hub threshold for link values
 // in - channel providing IngestData objects
 //
-func linkReverseChecker(ctx context.Context, db *badger.DB, in <-chan IngestData) (
+func linkReverseChecker(ctx context.Context, db *badger.DB, wb batchWriter, rules linkRules, in <-chan IngestData) (
 	<-chan IngestData, // pass data on to next stage
 	<-chan error, // emits errors encountered to the pipeline
 	error) {
@@ -36,8 +45,17 @@ func linkReverseChecker(ctx context.Context, db *badger.DB, in <-chan IngestData
 		defer close(out)
 		defer close(errc)
 
+		// hubs seen by this stage, flags written to the
+		// batch are not visible in the db until it is flushed
+		hubs := make(map[string]struct{})
+
+		clock := stageClockFrom(ctx)
 		for igd := range in {
-			linksTo := make(map[string]interface{}, 0)
+			start := time.Now()
+			// reverse links, labelled with the predicates
+			// that hold the shared value on each side
+			linksTo := make(map[Triple]struct{}, 0)
+			candidates := make([]Triple, 0, len(igd.LinkCandidates))
 			// first see if anything reverse links
 			// by checking for the presence of the object member
 			err := db.View(func(txn *badger.Txn) error {
@@ -46,14 +64,47 @@ func linkReverseChecker(ctx context.Context, db *badger.DB, in <-chan IngestData
 				it := txn.NewIterator(opts)
 				defer it.Close()
 				for _, candidate := range igd.LinkCandidates {
-					if len(candidate.O) > 0 { //don't link to empty content
-						prefix := []byte(fmt.Sprintf("ops|%s|", candidate.O))
-						for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-							item := it.Item()
-							t := NewTriple(string(item.KeyCopy(nil)))
-							linksTo[t.S] = struct{}{}
-
+					if len(candidate.O) == 0 { //don't link to empty content
+						candidates = append(candidates, candidate)
+						continue
+					}
+					if _, ok := hubs[candidate.O]; ok || rules.stopped(candidate.O) {
+						continue
+					}
+					flagged, err := isFlaggedHub(txn, candidate.O)
+					if err != nil {
+						return err
+					}
+					if flagged {
+						hubs[candidate.O] = struct{}{}
+						continue
+					}
+					matches := make([]Triple, 0)
+					holders := make(map[string]struct{})
+					predicates := make(map[string]struct{})
+					prefix := []byte(fmt.Sprintf("ops|%s|", candidate.O))
+					for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+						item := it.Item()
+						t := NewTriple(string(item.KeyCopy(nil)))
+						matches = append(matches,
+							Triple{S: "reverse", P: candidate.P, O: t.S},
+							Triple{S: "reverse", P: t.P, O: t.S})
+						holders[t.S] = struct{}{}
+						predicates[edgeLabel(t.P)] = struct{}{}
+					}
+					if rules.isHub(len(holders)) {
+						hub := Hub{Value: candidate.O, Degree: len(holders), Flagged: time.Now()}
+						for p := range predicates {
+							hub.Predicates = append(hub.Predicates, p)
 						}
+						sort.Strings(hub.Predicates)
+						igd.Hubs = append(igd.Hubs, hub)
+						hubs[candidate.O] = struct{}{}
+						continue
+					}
+					candidates = append(candidates, candidate)
+					for _, m := range matches {
+						linksTo[m] = struct{}{}
 					}
 				}
 				return nil
@@ -62,17 +113,25 @@ func linkReverseChecker(ctx context.Context, db *badger.DB, in <-chan IngestData
 				errc <- errors.Wrap(err, "linkReverseChecker() database iterator error:")
 				return
 			}
+			for _, hub := range igd.Hubs {
+				b, err := json.Marshal(hub)
+				if err != nil {
+					errc <- errors.Wrap(err, "linkReverseChecker() cannot encode hub:")
+					return
+				}
+				if err := wb.Set([]byte(hubPrefix+hub.Value), b); err != nil {
+					errc <- errors.Wrap(err, "linkReverseChecker() cannot flag hub:")
+					return
+				}
+			}
 
 			// add any reverse links to the list of viable candidates
-			for k, _ := range linksTo {
-				reverseLinkTriple := Triple{
-					S: "reverse",
-					P: "link",
-					O: k,
-				}
+			igd.LinkCandidates = candidates
+			for reverseLinkTriple := range linksTo {
 				igd.LinkCandidates = append(igd.LinkCandidates, reverseLinkTriple)
 			}
 
+			clock.add(start)
 			select {
 			case out <- igd: // pass the data on to the next stage
 			case <-ctx.Done(): // listen for pipeline shutdown
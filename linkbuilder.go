@@ -5,6 +5,7 @@ package deep6
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
@@ -16,10 +17,11 @@ import (
 //
 // ctx - pipeline management context
 // db - badger db used for lookups of objects to link to
-// wb - badger.Writebatch for fast writing of new link objects
+// wb - badger.Writebatch for fast writing of new link objects,
+// or a discardWriter for a dry run
 // in - channel providing IngestData objects
 //
-func linkBuilder(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, in <-chan IngestData) (
+func linkBuilder(ctx context.Context, db *badger.DB, wb batchWriter, in <-chan IngestData) (
 	<-chan IngestData, // pass data on to next stage
 	<-chan error, // emits errors encountered to the pipeline
 	error) {
@@ -31,8 +33,13 @@ func linkBuilder(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, in <
 		defer close(out)
 		defer close(errc)
 
+		clock := stageClockFrom(ctx)
 		for igd := range in {
-			linksTo := make(map[string]interface{}, 0)
+			start := time.Now()
+			// the objects to link to, each with the labels
+			// of the edges that explain the link
+			linksTo := make(map[string]map[string]struct{}, 0)
+			var accepted []string
 			// first see if anything links
 			err := db.View(func(txn *badger.Txn) error {
 				opts := badger.DefaultIteratorOptions
@@ -44,9 +51,16 @@ func linkBuilder(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, in <
 					for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 						item := it.Item()
 						t := NewTriple(string(item.KeyCopy(nil)))
-						linksTo[t.S] = struct{}{}
+						addEdge(linksTo, t.S, edgeLabel(candidate.P))
+						// links to or from a crosswalk also
+						// join the objects it declares the same
+						if t.O == sameAsType || igd.Type == sameAsType {
+							addEdge(linksTo, t.S, sameAsEdgeLabel)
+						}
 					}
 				}
+				// matches accepted with other objects (see AcceptMatch())
+				accepted = acceptedMatches(txn, it, igd.N3id)
 				return nil
 			})
 			if err != nil {
@@ -59,9 +73,10 @@ func linkBuilder(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, in <
 			// entity needs to be created, may be temporary as will resolve
 			// to 'real' objects as more data arrives.
 			//
-			if len(linksTo) < len(igd.LinkCandidates) {
+			if len(linksTo) < countCandidates(igd.LinkCandidates) {
 				for _, candidate := range igd.LinkCandidates {
 					if _, ok := linksTo[candidate.O]; ok { // only build a link if needed
+						addEdge(linksTo, candidate.O, edgeLabel(candidate.P))
 						continue
 					}
 					if candidate.O == "" { // ignore empty links
@@ -83,8 +98,12 @@ func linkBuilder(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, in <
 							return
 						}
 						// add new link
-						linksTo[propertyLinkTriple.S] = struct{}{}
+						addEdge(linksTo, propertyLinkTriple.S, edgeLabel(candidate.P))
+						if igd.Type == sameAsType {
+							addEdge(linksTo, propertyLinkTriple.S, sameAsEdgeLabel)
+						}
 					}
+					igd.PropertyLinks = append(igd.PropertyLinks, propertyLinkTriple.S)
 				}
 			}
 
@@ -105,13 +124,32 @@ func linkBuilder(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, in <
 						errc <- errors.Wrap(err, "cannot commit uniquelink:")
 						return
 					}
-					linksTo[uniqueLinkTriple.S] = struct{}{}
+					addEdge(linksTo, uniqueLinkTriple.S, uniqueEdgeLabel)
 				}
 			}
 
+			// links the object has asked for explicitly
+			for _, l := range igd.ExplicitLinks {
+				addEdge(linksTo, l, explicitEdgeLabel)
+			}
+			// and matches accepted for it
+			for _, l := range accepted {
+				addEdge(linksTo, l, explicitEdgeLabel)
+			}
+
 			// convert all known links into link triples
+			// along with a labelled edge for each reason to link
+			stored := make(map[Triple]struct{}, len(igd.StoredLinks)+len(igd.StoredEdges))
+			for _, t := range igd.StoredLinks {
+				stored[t] = struct{}{}
+			}
+			for _, t := range igd.StoredEdges {
+				stored[t] = struct{}{}
+			}
 			linkTriples := make([]Triple, 0)
-			for l, _ := range linksTo {
+			edgeTriples := make([]Triple, 0)
+			built := make(map[Triple]struct{})
+			for l, labels := range linksTo {
 				if l == igd.N3id {
 					continue // don't self link
 				}
@@ -120,10 +158,34 @@ func linkBuilder(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, in <
 					P: "references",
 					O: l,
 				}
-				linkTriples = append(linkTriples, t)
+				built[t] = struct{}{}
+				if _, ok := stored[t]; !ok { // link may already be in the graph
+					linkTriples = append(linkTriples, t)
+				}
+				for label := range labels {
+					e := Triple{
+						S: igd.N3id,
+						P: edgePrefix + label,
+						O: l,
+					}
+					built[e] = struct{}{}
+					if _, ok := stored[e]; !ok {
+						edgeTriples = append(edgeTriples, e)
+					}
+				}
 			}
 			igd.LinkTriples = linkTriples
+			igd.EdgeTriples = edgeTriples
 
+			// an object being relinked has all of its links built
+			// from all of its values, so any link it made before
+			// that has not been built again is stale
+			if igd.Relink {
+				igd.StaleLinks = unbuilt(igd.N3id, igd.StoredLinks, built)
+				igd.StaleEdges = unbuilt(igd.N3id, igd.StoredEdges, built)
+			}
+
+			clock.add(start)
 			select {
 			case out <- igd: // pass the data on to the next stage
 			case <-ctx.Done(): // listen for pipeline shutdown
@@ -135,3 +197,41 @@ func linkBuilder(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, in <
 	return out, errc, nil
 
 }
+
+//
+// counts the candidate links of an object, a reverse link is
+// counted once per target however many labels it carries.
+//
+func countCandidates(candidates []Triple) int {
+
+	n := 0
+	reverse := make(map[string]struct{})
+	for _, c := range candidates {
+		if c.S == "reverse" {
+			reverse[c.O] = struct{}{}
+			continue
+		}
+		n++
+	}
+	return n + len(reverse)
+
+}
+
+//
+// returns the stored links made by the object that
+// are not among those built for it
+//
+func unbuilt(id string, stored []Triple, built map[Triple]struct{}) []Triple {
+
+	stale := make([]Triple, 0)
+	for _, t := range stored {
+		if t.S != id {
+			continue // made by the other object
+		}
+		if _, ok := built[t]; !ok {
+			stale = append(stale, t)
+		}
+	}
+	return stale
+
+}
@@ -0,0 +1,84 @@
+// linkdiffer.go
+
+package deep6
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+)
+
+//
+// finds the stored links and labelled edges of an updated object
+// that are no longer supported by its data, so they can be removed
+// by the link writer.
+//
+// only the values removed from the object are traced, links of a
+// new object or one that has only gained values are left alone.
+//
+// ctx - pipeline management context
+// db - the badger db, to trace the nodes the object links to
+// sbf - bloom filter holding the values registered as links
+// in - channel providing IngestData objects
+//
+func linkDiffer(ctx context.Context, db *badger.DB, sbf *linkFilter, in <-chan IngestData) (
+	<-chan IngestData, // pass on to next stage
+	<-chan error, // emits errors encountered to the pipeline
+	error) { // returns any error encountered creating this component
+
+	out := make(chan IngestData)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errc)
+
+		clock := stageClockFrom(ctx)
+		for igd := range in {
+			start := time.Now()
+			if len(igd.StoredLinks)+len(igd.StoredEdges) > 0 && len(igd.RemovedTriples) > 0 {
+				removed := make(map[string]struct{})
+				for _, t := range igd.RemovedTriples {
+					removed[t.O] = struct{}{}
+				}
+				current := make(map[string]struct{})
+				for _, t := range igd.Triples {
+					if t.O != igd.N3id && sbf.Test([]byte(t.O)) {
+						current[t.O] = struct{}{}
+					}
+				}
+				if len(igd.Unique) > 0 {
+					current[igd.Unique] = struct{}{}
+				}
+				for v := range current {
+					delete(removed, v) // value still held by another property
+				}
+
+				stale, err := staleLinks(db, igd.StoredLinks, removed, current)
+				if err != nil {
+					errc <- errors.Wrap(err, "linkDiffer() database iterator error:")
+					return
+				}
+				igd.StaleLinks = stale
+				staleEdges, err := staleEdges(db, igd.N3id, igd.StoredEdges, removed, igd.Triples)
+				if err != nil {
+					errc <- errors.Wrap(err, "linkDiffer() database iterator error:")
+					return
+				}
+				igd.StaleEdges = staleEdges
+			}
+
+			clock.add(start)
+			select {
+			case out <- igd: // pass the data on to the next stage
+			case <-ctx.Done(): // listen for pipeline shutdown
+				return
+			}
+		}
+	}()
+
+	return out, errc, nil
+
+}
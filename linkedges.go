@@ -0,0 +1,61 @@
+// linkedges.go
+
+package deep6
+
+import (
+	"strings"
+)
+
+//
+// Alongside each generic S|references|O link, the link builder
+// writes a labelled edge S|via:<label>|O for each reason the
+// objects are linked, so a traversal can choose which kinds of
+// link to follow.
+//
+// The label is the predicate that holds the shared value, with
+// array indices written as '#' (the same form as a link spec)
+// e.g. via:actor.mbox or via:PersonInfo.EmailList.Email.#.value;
+// links found by the reverse check are labelled with the
+// predicates of both objects.
+//
+const edgePrefix = "via:"
+
+//
+// labels for links that do not come from a shared value
+//
+const (
+	// link to the pseudo-unique key of the object
+	uniqueEdgeLabel = "unique"
+	// link requested by the object itself e.g. an xAPI StatementRef
+	explicitEdgeLabel = "explicit"
+)
+
+//
+// returns the edge label for a link made through a predicate
+//
+func edgeLabel(predicate string) string {
+
+	predicate = strings.TrimPrefix(predicate, normalisedPrefix)
+	segments := strings.Split(predicate, ".")
+	for i, seg := range segments {
+		if isIndex(seg) {
+			segments[i] = "#"
+		}
+	}
+	return strings.Join(segments, ".")
+
+}
+
+//
+// records a labelled edge to the target
+//
+func addEdge(linksTo map[string]map[string]struct{}, target, label string) {
+
+	labels, ok := linksTo[target]
+	if !ok {
+		labels = make(map[string]struct{})
+		linksTo[target] = labels
+	}
+	labels[label] = struct{}{}
+
+}
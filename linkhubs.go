@@ -0,0 +1,147 @@
+// linkhubs.go
+
+package deep6
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+)
+
+//
+// key prefix for link values flagged as hubs, the
+// value of the key is the json encoded Hub
+//
+const hubPrefix = "hub|"
+
+//
+// Rules that keep over-connected values out of the graph,
+// from the [links] section of ./config/datatypes.toml.
+//
+// Values such as "true" or "en-US" are held by so many objects
+// that linking through them joins everything to everything.
+//
+type linkRules struct {
+	// values that are never used to link objects
+	StopValues []string `toml:"stop_values"`
+	// a value held by more than this number of objects is
+	// flagged as a hub and no longer used to link, 0 for no limit
+	MaxDegree int `toml:"max_degree"`
+
+	stop map[string]struct{}
+}
+
+//
+// A link value that is held by too many objects to be used
+// for linking, as found by an ingest.
+//
+type Hub struct {
+	// the link value
+	Value string
+	// number of objects that held the value when it was flagged
+	Degree int
+	// the properties that hold the value, as edge labels
+	// (see linkedges.go), a guide to which link specs to tune
+	Predicates []string
+	// when the value was flagged
+	Flagged time.Time
+}
+
+//
+// returns a copy of the rules ready for use
+//
+func (lr linkRules) prepare() linkRules {
+
+	lr.StopValues = append([]string(nil), lr.StopValues...)
+	lr.stop = make(map[string]struct{}, len(lr.StopValues))
+	for _, v := range lr.StopValues {
+		lr.stop[v] = struct{}{}
+	}
+	return lr
+
+}
+
+//
+// reports whether the value is on the stop-list
+//
+func (lr linkRules) stopped(value string) bool {
+	_, ok := lr.stop[value]
+	return ok
+}
+
+//
+// reports whether the number of objects holding a
+// value makes it a hub
+//
+func (lr linkRules) isHub(degree int) bool {
+	return lr.MaxDegree > 0 && degree > lr.MaxDegree
+}
+
+//
+// reports whether the value has been flagged as a hub
+//
+func isFlaggedHub(txn *badger.Txn, value string) (bool, error) {
+
+	_, err := txn.Get([]byte(hubPrefix + value))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+
+}
+
+//
+// Lists the link values flagged as hubs, most connected first.
+//
+// Hub values are no longer used to create links; links made
+// through a value before it was flagged are left in place.
+//
+func (d6 *Deep6DB) Hubs() ([]Hub, error) {
+
+	hubs := make([]Hub, 0)
+	err := d6.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		prefix := []byte(hubPrefix)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			b, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			var h Hub
+			if err := json.Unmarshal(b, &h); err != nil {
+				return errors.Wrap(err, "cannot decode hub: "+string(it.Item().Key()))
+			}
+			hubs = append(hubs, h)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read hubs:")
+	}
+	sort.SliceStable(hubs, func(i, j int) bool {
+		return hubs[i].Degree > hubs[j].Degree
+	})
+
+	return hubs, nil
+
+}
+
+//
+// Removes the hub flag from a value, e.g. after raising
+// max_degree, so later ingests can link through it again.
+//
+func (d6 *Deep6DB) ClearHub(value string) error {
+
+	err := d6.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(hubPrefix + value))
+	})
+	return errors.Wrap(err, "cannot clear hub: "+value)
+
+}
@@ -4,9 +4,7 @@ package deep6
 
 import (
 	"context"
-	"strings"
-
-	boom "github.com/tylertreat/BoomFilters"
+	"time"
 )
 
 //
@@ -16,7 +14,7 @@ import (
 // sbf - bloom filter used to capture required link fields between objects
 // in - channel providing IngestData objects
 //
-func linkParser(ctx context.Context, sbf *boom.ScalableBloomFilter, in <-chan IngestData) (
+func linkParser(ctx context.Context, sbf linkTracer, in <-chan IngestData) (
 	<-chan IngestData, // new list of triples also containing links
 	<-chan error, // emits errors encountered to the pipeline
 	error) { // returns any errors when creating this component
@@ -28,7 +26,9 @@ func linkParser(ctx context.Context, sbf *boom.ScalableBloomFilter, in <-chan In
 		defer close(out)
 		defer close(errc)
 
+		clock := stageClockFrom(ctx)
 		for igd := range in {
+			start := time.Now()
 			//
 			// extract the object (O:) members of any tuples that match the linking predicate
 			//
@@ -36,13 +36,15 @@ func linkParser(ctx context.Context, sbf *boom.ScalableBloomFilter, in <-chan In
 			// as they've been specified as linkable properties
 			// so we add them to the bloom filter
 			//
+			// values are traced in their normalised form, which
+			// is also held in the object's normalised triples so
+			// that the candidates below can match it
+			//
 			linkTraces := make([]string, 0)
+			specs := parseLinkSpecs(igd.LinkSpecs, igd.Normalise)
 			for _, t := range igd.Triples {
-				for _, s := range igd.LinkSpecs {
-					if strings.Contains(t.P, s) {
-						linkTrace := t.O
-						linkTraces = append(linkTraces, linkTrace)
-					}
+				if spec := matchLinkSpecs(specs, t.P, igd.LinkSuffixes); spec != nil {
+					linkTraces = append(linkTraces, normaliseValue(spec.normalise, t.O))
 				}
 			}
 			//
@@ -62,8 +64,14 @@ func linkParser(ctx context.Context, sbf *boom.ScalableBloomFilter, in <-chan In
 			// did some other object leave a linkTrace that we should
 			// observe becasue it is valid for our data properties
 			//
+			// for an update only the values that have been
+			// added can create new links
+			triples := igd.Triples
+			if igd.Replaced && !igd.Relink {
+				triples = igd.AddedTriples
+			}
 			links := make([]Triple, 0)
-			for _, t := range igd.Triples {
+			for _, t := range triples {
 				if t.O == igd.N3id {
 					continue // ignore self-links
 				}
@@ -76,6 +84,7 @@ func linkParser(ctx context.Context, sbf *boom.ScalableBloomFilter, in <-chan In
 
 			igd.LinkCandidates = links
 
+			clock.add(start)
 			select {
 			case out <- igd: // pass the data on to the next stage
 			case <-ctx.Done(): // listen for pipeline shutdown
@@ -29,7 +29,7 @@ func linkRemover(ctx context.Context, wb *badger.WriteBatch, in <-chan IngestDat
 		defer close(errc)
 
 		for igd := range in {
-			for _, t := range igd.LinkTriples {
+			for _, t := range append(igd.LinkTriples, igd.EdgeTriples...) {
 				for _, hexa := range t.SextupleLink() { // each entry as hexastore links
 					err := wb.Delete([]byte(hexa))
 					if err != nil {
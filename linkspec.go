@@ -0,0 +1,155 @@
+// linkspec.go
+
+package deep6
+
+import (
+	"strings"
+)
+
+//
+// A link spec is a path to the values of an object that are
+// linked into the graph, matched against the predicates of the
+// object's triples, which are the flattened paths of its values
+// e.g. StudentPersonal.OtherIdList.OtherId.0.value
+//
+// Path segments are separated by '.', a '#' segment matches
+// any array index and a '*' segment matches any single key or
+// index. Matching is of whole segments and anchored at both ends,
+// so "Lesson.stage" matches only the stage of a Lesson and not
+// Lesson.thestage or Lesson.stage.notes; a spec that names an
+// array of values links every member.
+//
+// With suffix matching the spec may also match the trailing
+// segments of a predicate, so "Name.FamilyName" matches
+// StudentPersonal.PersonInfo.Name.FamilyName.
+//
+// Before matching was anchored, specs matched any predicate that
+// contained them, so configs written then list links as bare keys
+// e.g. "RefId"; these need suffix matching to match at any depth,
+// and it still only matches whole keys, so "RefId" matches
+// StudentPersonal.RefId but not StudentPersonal.SchoolInfoRefId.
+//
+// Values matched by a spec are passed through its normalisers
+// (see normalise.go) before they are compared with other values.
+//
+type linkSpec struct {
+	segments  []string
+	normalise []string
+}
+
+//
+// splits the link specs of an object into their segments
+//
+// specs - link specs of the object
+// normalise - normalisers for each spec, if any
+//
+func parseLinkSpecs(specs []string, normalise map[string][]string) []linkSpec {
+
+	parsed := make([]linkSpec, 0, len(specs))
+	for _, s := range specs {
+		parsed = append(parsed, linkSpec{
+			segments:  strings.Split(s, "."),
+			normalise: normalise[s],
+		})
+	}
+	return parsed
+
+}
+
+//
+// returns the first of the specs that matches the predicate,
+// nil if none do
+//
+// specs - parsed link specs of the object
+// predicate - predicate of a triple
+// suffix - whether specs can match the end of the predicate
+//
+func matchLinkSpecs(specs []linkSpec, predicate string, suffix bool) *linkSpec {
+
+	if len(specs) == 0 || isNormalised(predicate) {
+		return nil
+	}
+	segments := strings.Split(predicate, ".")
+	for i := range specs {
+		spec := &specs[i]
+		if spec.matchFrom(segments, 0) {
+			return spec
+		}
+		if !suffix {
+			continue
+		}
+		for start := 1; start < len(segments); start++ {
+			if spec.matchFrom(segments, start) {
+				return spec
+			}
+		}
+	}
+	return nil
+
+}
+
+//
+// returns a triple holding the normalised form of the
+// value of t, if the spec changes it
+//
+func (spec *linkSpec) normalisedTriple(t Triple) (Triple, bool) {
+
+	if len(spec.normalise) == 0 {
+		return Triple{}, false
+	}
+	value := normaliseValue(spec.normalise, t.O)
+	if value == t.O || value == "" {
+		return Triple{}, false
+	}
+	return Triple{S: t.S, P: normalisedPrefix + t.P, O: value}, true
+
+}
+
+//
+// matches the spec against the predicate segments from start,
+// any segments left over must be array indices
+//
+func (spec *linkSpec) matchFrom(segments []string, start int) bool {
+
+	if len(segments)-start < len(spec.segments) {
+		return false
+	}
+	for i, s := range spec.segments {
+		seg := segments[start+i]
+		switch s {
+		case "*":
+		case "#":
+			if !isIndex(seg) {
+				return false
+			}
+		default:
+			if s != seg {
+				return false
+			}
+		}
+	}
+	for _, seg := range segments[start+len(spec.segments):] {
+		if !isIndex(seg) {
+			return false
+		}
+	}
+	return true
+
+}
+
+//
+// reports whether a path segment is an array index
+//
+func isIndex(seg string) bool {
+
+	if seg == "" {
+		return false
+	}
+	for _, r := range seg {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+
+}
@@ -0,0 +1,57 @@
+// linkspec_test.go
+
+package deep6
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchLinkSpecs(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		specs     []string
+		predicate string
+		suffix    bool
+		want      string // spec matched, empty for none
+	}{
+		{"exact path", []string{"StudentPersonal.RefId"}, "StudentPersonal.RefId", false, "StudentPersonal.RefId"},
+		{"anchored at start", []string{"PersonInfo.Name"}, "StudentPersonal.PersonInfo.Name", false, ""},
+		{"anchored at end", []string{"StudentPersonal.PersonInfo"}, "StudentPersonal.PersonInfo.Name", false, ""},
+		{"whole segments only", []string{"Lesson.stage"}, "Lesson.thestage", false, ""},
+		{"wildcard key", []string{"*.RefId"}, "SchoolInfo.RefId", false, "*.RefId"},
+		{"wildcard is one segment", []string{"*.RefId"}, "StudentPersonal.PersonInfo.RefId", false, ""},
+		{"index wildcard", []string{"StudentPersonal.OtherIdList.OtherId.#.value"}, "StudentPersonal.OtherIdList.OtherId.2.value", false, "StudentPersonal.OtherIdList.OtherId.#.value"},
+		{"index wildcard needs an index", []string{"OtherId.#.value"}, "OtherId.first.value", false, ""},
+		{"array members", []string{"Subject.synonyms"}, "Subject.synonyms.3", false, "Subject.synonyms"},
+		{"trailing key is not an index", []string{"Subject.synonyms"}, "Subject.synonyms.text", false, ""},
+		{"suffix off", []string{"Name.FamilyName"}, "StudentPersonal.PersonInfo.Name.FamilyName", false, ""},
+		{"suffix on", []string{"Name.FamilyName"}, "StudentPersonal.PersonInfo.Name.FamilyName", true, "Name.FamilyName"},
+		{"suffix on, whole segments", []string{"Name.FamilyName"}, "StudentPersonal.PersonInfo.OtherName.FamilyName", true, ""},
+		{"bare key at top level", []string{"userId"}, "userId", false, "userId"},
+		{"bare key anchored", []string{"RefId"}, "StudentPersonal.RefId", false, ""},
+		{"bare key at any depth with suffixes", []string{"RefId"}, "StudentPersonal.RefId", true, "RefId"},
+		{"bare key, whole key only", []string{"RefId"}, "StudentPersonal.SchoolInfoRefId", true, ""},
+		{"bare key array member", []string{"sameAs"}, "sameAs.1", false, "sameAs"},
+		{"bare wildcard is one segment", []string{"*"}, "StudentPersonal.RefId", false, ""},
+		{"first match wins", []string{"LocalId", "*.RefId"}, "SchoolInfo.RefId", false, "*.RefId"},
+		{"normalised predicates ignored", []string{"actor.mbox"}, normalisedPrefix + "actor.mbox", true, ""},
+		{"no specs", nil, "StudentPersonal.RefId", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchLinkSpecs(parseLinkSpecs(tt.specs, nil), tt.predicate, tt.suffix)
+			switch {
+			case got == nil && tt.want != "":
+				t.Errorf("%s: no match, want %s", tt.predicate, tt.want)
+			case got != nil && tt.want == "":
+				t.Errorf("%s: matched %v, want no match", tt.predicate, got.segments)
+			case got != nil && strings.Join(got.segments, ".") != tt.want:
+				t.Errorf("%s: matched %v, want %s", tt.predicate, got.segments, tt.want)
+			}
+		})
+	}
+
+}
@@ -4,19 +4,28 @@ package deep6
 
 import (
 	"context"
+	"time"
 
-	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
 )
 
 //
 // commits all inter-object graph links to the datastore
 //
+// the labelled edges of the links are written with their own
+// writer, as the objects the linking stages can see depend on
+// when wb commits, which must not change with the number of edges
+// (see ingestEnv).
+//
+// the content hash of the object is written last, so an
+// object only counts as stored once it is complete.
+//
 // ctx - context for pipeline management
 // wb - badger.WriteBatch for fast writes to db
+// ewb - writer for the labelled edges of the links
 // in - channel providing IngestData objects
 //
-func linkWriter(ctx context.Context, wb *badger.WriteBatch, in <-chan IngestData) (
+func linkWriter(ctx context.Context, wb batchWriter, ewb batchWriter, in <-chan IngestData) (
 	<-chan IngestData, // new list of triples also containing links
 	<-chan error, // emits errors encountered to the pipeline
 	error) { // returns any error encountered constructing this component
@@ -28,16 +37,25 @@ func linkWriter(ctx context.Context, wb *badger.WriteBatch, in <-chan IngestData
 		defer close(out)
 		defer close(errc)
 
+		clock := stageClockFrom(ctx)
 		for igd := range in {
-			for _, t := range igd.LinkTriples {
-				for _, hexa := range t.SextupleLink() {
-					err := wb.Set([]byte(hexa), []byte{})
-					if err != nil {
-						errc <- errors.Wrap(err, "error writing link triples: ")
-						return
-					}
+			start := time.Now()
+			err := writeLinks(wb, igd.StaleLinks, igd.LinkTriples)
+			if err == nil {
+				err = writeLinks(ewb, igd.StaleEdges, igd.EdgeTriples)
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			if igd.ContentHash != "" {
+				err := wb.Set([]byte(contentHashPrefix+igd.N3id), []byte(igd.ContentHash))
+				if err != nil {
+					errc <- errors.Wrap(err, "error writing content hash: ")
+					return
 				}
 			}
+			clock.add(start)
 			select {
 			case out <- igd: // pass the map onto the next stage
 			case <-ctx.Done(): // listen for pipeline shutdown
@@ -49,3 +67,32 @@ func linkWriter(ctx context.Context, wb *badger.WriteBatch, in <-chan IngestData
 	return out, errc, nil
 
 }
+
+//
+// removes the stale link triples and writes the new ones
+//
+// w - writer for the triples
+// stale - link triples to remove
+// links - link triples to write
+//
+func writeLinks(w batchWriter, stale []Triple, links []Triple) error {
+
+	for _, t := range stale {
+		for _, hexa := range t.SextupleLink() {
+			err := w.Delete([]byte(hexa))
+			if err != nil {
+				return errors.Wrap(err, "error removing stale link triples: ")
+			}
+		}
+	}
+	for _, t := range links {
+		for _, hexa := range t.SextupleLink() {
+			err := w.Set([]byte(hexa), []byte{})
+			if err != nil {
+				return errors.Wrap(err, "error writing link triples: ")
+			}
+		}
+	}
+	return nil
+
+}
@@ -0,0 +1,109 @@
+// normalise.go
+
+package deep6
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//
+// predicate prefix for the triples that hold the normalised
+// form of a link value; they let lookups and reverse link
+// checks find the object by its normalised value, but are not
+// part of the object's data so are left out when it is read back.
+//
+const normalisedPrefix = "~normalised."
+
+//
+// The normalisers that can be applied to link values,
+// named in the normalise section of a classifier.
+//
+var normalisers = map[string]func(string) string{
+	// lower case
+	"lowercase": strings.ToLower,
+	// remove leading and trailing whitespace
+	"trim": strings.TrimSpace,
+	// remove a mailto: scheme from an email address
+	"strip_mailto": stripMailto,
+	// unicode normal form C
+	"nfc": norm.NFC.String,
+	// canonical form of a url
+	"url": canonicalURL,
+	// remove leading zeros from a whole number e.g. 007 -> 7
+	"strip_leading_zeros": stripLeadingZeros,
+}
+
+//
+// applies a list of normalisers to a value, in order
+//
+func normaliseValue(names []string, value string) string {
+
+	for _, name := range names {
+		if f, ok := normalisers[name]; ok {
+			value = f(value)
+		}
+	}
+	return value
+
+}
+
+//
+// reports whether a predicate holds a normalised value
+//
+func isNormalised(predicate string) bool {
+	return strings.HasPrefix(predicate, normalisedPrefix)
+}
+
+func stripMailto(s string) string {
+
+	if len(s) >= 7 && strings.EqualFold(s[:7], "mailto:") {
+		return s[7:]
+	}
+	return s
+
+}
+
+//
+// lower-cases the scheme and host, drops default ports,
+// fragments and trailing slashes, and sorts the query;
+// values that are not absolute urls are unchanged.
+//
+func canonicalURL(s string) string {
+
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return s
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = host + ":" + port
+	}
+	u.Host = host
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	u.RawQuery = u.Query().Encode()
+	u.Fragment = ""
+	return u.String()
+
+}
+
+func stripLeadingZeros(s string) string {
+
+	if !isIndex(s) {
+		return s
+	}
+	trimmed := strings.TrimLeft(s, "0")
+	if trimmed == "" {
+		return "0"
+	}
+	return trimmed
+
+}
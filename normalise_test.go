@@ -0,0 +1,74 @@
+// normalise_test.go
+
+package deep6
+
+import (
+	"testing"
+)
+
+func TestNormalisers(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		normaliser string
+		value      string
+		want       string
+	}{
+		{"lowercase", "lowercase", "Ann.Smith@Example.COM", "ann.smith@example.com"},
+		{"trim", "trim", "  L001 \t", "L001"},
+		{"strip mailto", "strip_mailto", "mailto:ann@example.com", "ann@example.com"},
+		{"strip mailto, any case", "strip_mailto", "MAILTO:ann@example.com", "ann@example.com"},
+		{"strip mailto, no scheme", "strip_mailto", "ann@example.com", "ann@example.com"},
+		{"strip mailto, not a prefix", "strip_mailto", "ann+mailto:@example.com", "ann+mailto:@example.com"},
+		{"nfc composes", "nfc", "Jose\u0301", "Jos\u00e9"},
+		{"nfc, already composed", "nfc", "Jos\u00e9", "Jos\u00e9"},
+		{"url scheme and host", "url", "HTTP://Example.COM/Act/1", "http://example.com/Act/1"},
+		{"url default port", "url", "https://example.com:443/act", "https://example.com/act"},
+		{"url other port kept", "url", "http://example.com:8080/act", "http://example.com:8080/act"},
+		{"url trailing slash", "url", "http://example.com/act/", "http://example.com/act"},
+		{"url fragment", "url", "http://example.com/act#part", "http://example.com/act"},
+		{"url query sorted", "url", "http://example.com/act?b=2&a=1", "http://example.com/act?a=1&b=2"},
+		{"url, not absolute", "url", "/act/1/", "/act/1/"},
+		{"url, not a url", "url", "Act 1", "Act 1"},
+		{"leading zeros", "strip_leading_zeros", "000123", "123"},
+		{"leading zeros, all zeros", "strip_leading_zeros", "000", "0"},
+		{"leading zeros, not a number", "strip_leading_zeros", "007a", "007a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, ok := normalisers[tt.normaliser]
+			if !ok {
+				t.Fatalf("no normaliser %s", tt.normaliser)
+			}
+			if got := f(tt.value); got != tt.want {
+				t.Errorf("%s(%q) = %q, want %q", tt.normaliser, tt.value, got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestNormaliseValue(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		names []string
+		value string
+		want  string
+	}{
+		{"none", nil, " MAILTO:Ann@Example.com ", " MAILTO:Ann@Example.com "},
+		{"applied in order", []string{"trim", "strip_mailto", "lowercase"}, " MAILTO:Ann@Example.com ", "ann@example.com"},
+		{"order matters", []string{"strip_mailto", "trim", "lowercase"}, " MAILTO:Ann@Example.com ", "mailto:ann@example.com"},
+		{"unknown names ignored", []string{"soundex", "lowercase"}, "ANN", "ann"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normaliseValue(tt.names, tt.value); got != tt.want {
+				t.Errorf("normaliseValue(%v, %q) = %q, want %q", tt.names, tt.value, got, tt.want)
+			}
+		})
+	}
+
+}
@@ -4,25 +4,27 @@ package deep6
 
 import (
 	"context"
+	"time"
 
 	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
-	boom "github.com/tylertreat/BoomFilters"
 )
 
 //
 // checks to see if this is an update to an existing object in the graph
-// if so removes the current version to make way for new one.
+// if so reads the current version, so that later stages write only
+// the triples and links that differ between the two versions.
+//
+// Objects whose content hash matches the stored version are
+// unchanged, they are marked as such and passed on without removal
+// so later stages can skip them, making a reload of a full
+// snapshot cost roughly the same as loading the changes.
 //
 // ctx: Context
 // db: the badger instance
-// wb: WriteBatch from the db to handle deletes
-// sbf: boom filter for classifier
-// auditLevel: diagnostic ouput level
-// folderPath: support file location for configs etc.
 // in: inbound channel of ingest data strucures
 //
-func objectRemover(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, sbf *boom.ScalableBloomFilter, auditLevel, folderPath string, in <-chan IngestData) (
+func objectRemover(ctx context.Context, db *badger.DB, in <-chan IngestData) (
 	<-chan IngestData, // pass on to next stage
 	<-chan error, // emits errors encountered to the pipeline
 	error) { // returns any error encountered creating this component
@@ -34,13 +36,53 @@ func objectRemover(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, sb
 		defer close(out)
 		defer close(errc)
 
+		// objects already seen in this stream; a later version
+		// of the same object is always re-processed, as the
+		// stored hash does not reflect the earlier version
+		seen := make(map[string]struct{})
+
+		clock := stageClockFrom(ctx)
 		for igd := range in {
+			start := time.Now()
 			id := igd.N3id
-			err := deleteWithID(id, db, wb, sbf, auditLevel, folderPath)
-			if err != nil && err != ErrNotFound {
-				errc <- errors.Wrap(err, "error removing existing object")
+
+			hash, err := contentHash(igd)
+			if err != nil {
+				errc <- errors.Wrap(err, "cannot create content hash")
+				return
+			}
+			storedHash, err := storedContentHash(id, db)
+			if err != nil {
+				errc <- errors.Wrap(err, "cannot read content hash")
+				return
+			}
+			_, repeat := seen[id]
+			seen[id] = struct{}{}
+			if id != "" { // unidentified objects cannot be matched to a stored version
+				igd.ContentHash = hash
+			}
+			if id != "" && !repeat && hash == storedHash {
+				igd.Unchanged = true
+				clock.add(start)
+				select {
+				case out <- igd: // pass the data on to the next stage
+				case <-ctx.Done(): // listen for pipeline shutdown
+					return
+				}
+				continue
+			}
+
+			igd.StoredTriples, igd.StoredLinks, igd.StoredEdges, err = findStoredObject(id, db)
+			if err != nil {
+				errc <- errors.Wrap(err, "error reading existing object")
 				return
 			}
+			igd.Replaced = len(igd.StoredTriples) > 0
+			// a stored object with no hash was not written completely
+			// (e.g. the ingest was cancelled), so its links cannot be
+			// trusted and are rebuilt from all of its values
+			igd.Relink = igd.Replaced && storedHash == ""
+			clock.add(start)
 			select {
 			case out <- igd: // pass the data on to the next stage
 			case <-ctx.Done(): // listen for pipeline shutdown
@@ -5,11 +5,9 @@ package deep6
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"strings"
+	"time"
 
-	"github.com/BurntSushi/toml"
-	"github.com/nats-io/nuid"
 	"github.com/pkg/errors"
 	"github.com/tidwall/gjson"
 )
@@ -18,14 +16,17 @@ import (
 // Identifies & classifies the object passed in from the
 // upstream reader.
 //
-// Uses the config in ./config/datatype.toml for deriving the
-// data model, unique id etc.
+// Uses the classifiers from the registry (by default the config
+// in ./config/datatype.toml) for deriving the data model, unique id etc.
 //
 // ctx - context to manage the pipeline
-// in - channel providing map[string]interface{} containing
-// the json data
+// classifiers - the classifiers to use, in the order they are checked
+// dlq - dead-letter queue for objects that cannot be classified,
+// if nil any such object stops the pipeline
+// in - channel providing IngestData containing
+// the json data as map[string]interface{}
 //
-func objectClassifier(ctx context.Context, filePath string, in <-chan map[string]interface{}) (
+func objectClassifier(ctx context.Context, classifiers []*Classifier, dlq *deadLetterQueue, in <-chan IngestData) (
 	<-chan IngestData, // emits IngestData objects with classification elements
 	<-chan error, // emits errors encountered to the pipeline manager
 	error) { // any error encountered when creating this component
@@ -33,46 +34,44 @@ func objectClassifier(ctx context.Context, filePath string, in <-chan map[string
 	out := make(chan IngestData)
 	errc := make(chan error, 1)
 
-	// load the classifier definitions;
-	// each data-model type characterised by properties of the
-	// json data.
-	//
-	type classifier struct {
-		Data_model     string
-		Required_paths []string
-		N3id           string
-		Links          []string
-		Unique         []string
-	}
-	type classifiers struct {
-		Classifier []classifier
-	}
-	var c classifiers
-	classifierFile := fmt.Sprintf("%s/config/datatypes.toml", filePath)
-	if _, err := toml.DecodeFile(classifierFile, &c); err != nil {
-		return nil, nil, err
-	}
-
 	go func() {
 		defer close(out)
 		defer close(errc)
-		for jsonMap := range in { // read json object (map) from upstream source
+		clock := stageClockFrom(ctx)
+		for igd := range in { // read json object (map) from upstream source
+			start := time.Now()
 
+			jsonMap := igd.RawData
 			rawJson, err := json.Marshal(jsonMap) // we need json bytes for use with gjson
 			if err != nil {
-				errc <- errors.Wrap(err, "json marshal error")
-				return
+				err = errors.Wrap(err, "json marshal error")
+				if !dlq.reject(igd.Position, -1, nil, "object-classifier", err) {
+					errc <- err
+					return
+				}
+				clock.add(start)
+				continue
 			}
 
-			igd := IngestData{}
 			classified := false
 			var dataModel, objectType, n3id, unique string
 			var links, uniqueVals []string
 			//
 			// check the data by comparing with the known
-			// classificaiton attributes from the config
+			// classificaiton attributes from the config,
+			// classifiers are in priority order so the first
+			// match wins, but all are checked so that objects
+			// matching more than one can be reported
 			//
-			for _, classifier := range c.Classifier {
+			matches := make([]*Classifier, 0)
+			for _, classifier := range classifiers {
+				if classifier.matches(rawJson) {
+					matches = append(matches, classifier)
+				}
+			}
+			if len(matches) > 0 {
+				classified = true
+				classifier := matches[0]
 				// extract the fields required for a synthetic unique id
 				// if specified
 				if len(classifier.Unique) > 0 {
@@ -85,30 +84,22 @@ func objectClassifier(ctx context.Context, filePath string, in <-chan map[string
 					}
 					unique = strings.Join(uniqueVals, "-")
 				}
-				// now apply classification
-				results := gjson.GetManyBytes(rawJson, classifier.Required_paths...)
-				found := 0
-				for _, r := range results {
-					if r.Exists() {
-						found++
-					}
-				}
-				if len(classifier.Required_paths) == found {
-					classified = true
-				}
-				if classified {
-					// find the unique identifier for this object
-					// if no id available use a nuid
-					result := gjson.GetBytes(rawJson, classifier.N3id)
-					if result.Exists() {
-						n3id = result.String()
-					} else {
-						n3id = nuid.Next()
-					}
-					dataModel = classifier.Data_model
-					// collect link fields for this data type
-					links = classifier.Links
-					break
+				// find the unique identifier for this object
+				n3id, igd.contentId = classifier.n3id(rawJson)
+				dataModel = classifier.DataModel
+				// collect link fields for this data type
+				links = classifier.Links
+				igd.LinkSuffixes = classifier.LinkSuffixes
+				igd.Normalise = classifier.Normalise
+				// schema to validate the object against, if any
+				igd.schema = classifier.schema
+				igd.OnInvalid = classifier.OnInvalid
+				// the classifier can decide the object type
+				objectType = classifier.objectType(rawJson)
+				// note any other models the object could have been
+				igd.AlsoMatched = nil
+				for _, other := range matches[1:] {
+					igd.AlsoMatched = append(igd.AlsoMatched, other.DataModel)
 				}
 			}
 
@@ -117,18 +108,19 @@ func objectClassifier(ctx context.Context, filePath string, in <-chan map[string
 				dataModel = "JSON"
 			}
 
-			// set the object type
+			// set the object type, unless given by the classifier
 			// if only 1 top level key, derive object type from it (SIF)
 			// otherwise default to the datamodel as type (eg. xAPI)
-			keys := []string{}
-			for k := range jsonMap {
-				keys = append(keys, k)
-			}
-			if len(keys) == 1 {
-				objectType = keys[0]
-			} else {
-				objectType = dataModel
-
+			if objectType == "" {
+				keys := []string{}
+				for k := range jsonMap {
+					keys = append(keys, k)
+				}
+				if len(keys) == 1 {
+					objectType = keys[0]
+				} else {
+					objectType = dataModel
+				}
 			}
 
 			//
@@ -146,6 +138,24 @@ func objectClassifier(ctx context.Context, filePath string, in <-chan map[string
 			igd.RawData = jsonMap
 			igd.UniqueValues = uniqueVals
 
+			//
+			// in tolerant mode objects that cannot be identified
+			// are set aside rather than stored
+			//
+			if dlq != nil && (igd.N3id == "" || igd.Type == "") {
+				reason := errors.New("object has no n3id")
+				if igd.Type == "" {
+					reason = errors.New("object has no is-a type")
+				}
+				if !dlq.reject(igd.Position, -1, rawJson, "object-classifier", reason) {
+					errc <- reason
+					return
+				}
+				clock.add(start)
+				continue
+			}
+
+			clock.add(start)
 			select {
 			case out <- igd: // pass the data package on to the next stage
 			case <-ctx.Done(): // listen for pipeline shutdown
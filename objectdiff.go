@@ -0,0 +1,237 @@
+// objectdiff.go
+
+package deep6
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+//
+// reads the triples, outbound links and labelled edges (both
+// ways) currently stored for the object with the given id, so that an update can write
+// only the differences rather than removing and rebuilding
+// the object. No triples are returned if the object is not
+// in the graph.
+//
+func findStoredObject(id string, db *badger.DB) (triples, links, edges []Triple, err error) {
+
+	triples = make([]Triple, 0)
+	links = make([]Triple, 0)
+	edges = make([]Triple, 0)
+	err = db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		prefix := []byte(fmt.Sprintf("spo|%s|", id))
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			t := NewTriple(string(it.Item().KeyCopy(nil)))
+			if t.O == "Property.Link" || t.O == "Unique.Link" {
+				continue // link nodes are not part of the object data
+			}
+			triples = append(triples, t)
+		}
+		prefix = []byte(fmt.Sprintf("spol|%s|references|", id))
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			links = append(links, newLinkTriple(string(it.Item().KeyCopy(nil))))
+		}
+		prefix = []byte(fmt.Sprintf("spol|%s|%s", id, edgePrefix))
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			edges = append(edges, newLinkTriple(string(it.Item().KeyCopy(nil))))
+		}
+		// edges from other objects are labelled with reasons
+		// that can also depend on this object's data
+		prefix = []byte(fmt.Sprintf("ospl|%s|", id))
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			t := NewTriple(string(it.Item().KeyCopy(nil)))
+			if strings.HasPrefix(t.P, edgePrefix) {
+				edges = append(edges, t)
+			}
+		}
+		return nil
+	})
+
+	return triples, links, edges, err
+
+}
+
+//
+// parses an spol| link key, NewTriple() only handles
+// the plain triple prefixes.
+//
+func newLinkTriple(key string) Triple {
+	return NewTriple("spo" + key[len("spol"):])
+}
+
+//
+// compares the stored triples of an object with the
+// newly generated ones, returns the triples that need to be
+// added and those that need to be removed.
+//
+func diffTriples(stored, current []Triple) (added, removed []Triple) {
+
+	storedSet := make(map[Triple]struct{}, len(stored))
+	for _, t := range stored {
+		storedSet[t] = struct{}{}
+	}
+	currentSet := make(map[Triple]struct{}, len(current))
+	for _, t := range current {
+		currentSet[t] = struct{}{}
+	}
+
+	added = make([]Triple, 0)
+	for _, t := range current {
+		if _, ok := storedSet[t]; !ok {
+			added = append(added, t)
+		}
+	}
+	removed = make([]Triple, 0)
+	for _, t := range stored {
+		if _, ok := currentSet[t]; !ok {
+			removed = append(removed, t)
+		}
+	}
+
+	return added, removed
+
+}
+
+//
+// finds the stored links of an updated object that are no
+// longer supported by its data.
+//
+// A link to another node is made through a shared value; the
+// node is either identified by the value, or holds the value
+// as one of its own properties. A stored link is stale if it can
+// be traced to a value the object no longer has, and cannot be
+// traced to any value it still has.
+//
+// removed - the values removed from the object
+// current - the linkable values the object now has
+//
+func staleLinks(db *badger.DB, links []Triple, removed, current map[string]struct{}) ([]Triple, error) {
+
+	stale := make([]Triple, 0)
+	if len(removed) == 0 {
+		return stale, nil
+	}
+
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for _, link := range links {
+			_, lost := removed[link.O]
+			_, kept := current[link.O]
+			prefix := []byte(fmt.Sprintf("spo|%s|", link.O))
+			for it.Seek(prefix); it.ValidForPrefix(prefix) && !kept; it.Next() {
+				t := NewTriple(string(it.Item().Key()))
+				if _, ok := removed[t.O]; ok {
+					lost = true
+				}
+				if _, ok := current[t.O]; ok {
+					kept = true
+				}
+			}
+			if lost && !kept {
+				stale = append(stale, link)
+			}
+		}
+		return nil
+	})
+
+	return stale, err
+
+}
+
+//
+// finds the stored edges of an updated object whose reason
+// no longer holds.
+//
+// An edge between the object and another node holds while they
+// share a value that one of them has under the edge label; either
+// the node is the value itself (a property link node, or an id held
+// by the object) or the node holds the value as one of its own
+// properties; a sameAs edge to or from a crosswalk holds while they
+// share any value. Only edges to nodes touched by the removed values
+// are checked.
+//
+// id - the id of the object
+// edges - the stored edges to and from the object
+// removed - the values removed from the object
+// current - the triples the object now has
+//
+func staleEdges(db *badger.DB, id string, edges []Triple, removed map[string]struct{}, current []Triple) ([]Triple, error) {
+
+	stale := make([]Triple, 0)
+	if len(removed) == 0 || len(edges) == 0 {
+		return stale, nil
+	}
+	// labels under which the object holds each value
+	held := make(map[string]map[string]struct{})
+	for _, t := range current {
+		addEdge(held, t.O, edgeLabel(t.P))
+	}
+
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		nodes := make(map[string][]Triple)
+		for _, edge := range edges {
+			label := edge.P[len(edgePrefix):]
+			if label == uniqueEdgeLabel || label == explicitEdgeLabel {
+				continue // not made through a shared value
+			}
+			other := edge.O
+			if other == id {
+				other = edge.S
+			}
+			node, ok := nodes[other]
+			if !ok {
+				node = make([]Triple, 0)
+				prefix := []byte(fmt.Sprintf("spo|%s|", other))
+				for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+					node = append(node, NewTriple(string(it.Item().KeyCopy(nil))))
+				}
+				nodes[other] = node
+			}
+			_, touched := removed[other]
+			for _, t := range node {
+				if _, ok := removed[t.O]; ok {
+					touched = true
+				}
+			}
+			if !touched {
+				continue
+			}
+			// a crosswalk edge holds while any value is shared
+			crosswalk := label == sameAsEdgeLabel
+			_, holds := held[other][label]
+			if crosswalk {
+				_, holds = held[other]
+			}
+			for _, t := range node {
+				if holds {
+					break
+				}
+				if labels, ok := held[t.O]; ok {
+					_, local := labels[label]
+					holds = local || edgeLabel(t.P) == label || crosswalk
+				}
+			}
+			if !holds {
+				stale = append(stale, edge)
+			}
+		}
+		return nil
+	})
+
+	return stale, err
+
+}
@@ -0,0 +1,222 @@
+// objectdiff_test.go
+
+package deep6
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+//
+// opens an empty badger db in a temporary folder,
+// removed when the test ends
+//
+func openTestDB(t *testing.T) *badger.DB {
+
+	t.Helper()
+	dir, err := ioutil.TempDir("", "deep6-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return db
+
+}
+
+//
+// stores the triples as the triple writer does
+//
+func storeTriples(t *testing.T, db *badger.DB, triples ...Triple) {
+
+	t.Helper()
+	err := db.Update(func(txn *badger.Txn) error {
+		for _, tr := range triples {
+			for _, k := range tr.Sextuple() {
+				if err := txn.Set([]byte(k), []byte{}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+}
+
+func valueSet(values ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+func sortedTriples(triples []Triple) []Triple {
+	sorted := append([]Triple{}, triples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.S != b.S {
+			return a.S < b.S
+		}
+		if a.P != b.P {
+			return a.P < b.P
+		}
+		return a.O < b.O
+	})
+	return sorted
+}
+
+func TestDiffTriples(t *testing.T) {
+
+	name := Triple{S: "P1", P: "StudentPersonal.Name", O: "Ann"}
+	renamed := Triple{S: "P1", P: "StudentPersonal.Name", O: "Anne"}
+	local := Triple{S: "P1", P: "StudentPersonal.LocalId", O: "L1"}
+	email := Triple{S: "P1", P: "StudentPersonal.Email", O: "ann@example.com"}
+
+	tests := []struct {
+		name        string
+		stored      []Triple
+		current     []Triple
+		wantAdded   []Triple
+		wantRemoved []Triple
+	}{
+		{"unchanged", []Triple{name, local}, []Triple{local, name}, []Triple{}, []Triple{}},
+		{"new object", nil, []Triple{name, local}, []Triple{name, local}, []Triple{}},
+		{"value changed", []Triple{name, local}, []Triple{renamed, local}, []Triple{renamed}, []Triple{name}},
+		{"value added", []Triple{local}, []Triple{local, email}, []Triple{email}, []Triple{}},
+		{"value removed", []Triple{local, email}, []Triple{local}, []Triple{}, []Triple{email}},
+		{"everything removed", []Triple{name, local}, nil, []Triple{}, []Triple{name, local}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := diffTriples(tt.stored, tt.current)
+			if !reflect.DeepEqual(sortedTriples(added), sortedTriples(tt.wantAdded)) {
+				t.Errorf("added %v, want %v", added, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(sortedTriples(removed), sortedTriples(tt.wantRemoved)) {
+				t.Errorf("removed %v, want %v", removed, tt.wantRemoved)
+			}
+		})
+	}
+
+}
+
+func TestStaleLinks(t *testing.T) {
+
+	db := openTestDB(t)
+	storeTriples(t, db,
+		// a property link node for a shared value
+		Triple{S: "L1", P: "is-a", O: "Property.Link"},
+		// an object that holds the value the link was made through
+		Triple{S: "S0", P: "is-a", O: "SchoolInfo"},
+		Triple{S: "S0", P: "SchoolInfo.RefId", O: "S0"},
+		Triple{S: "S0", P: "SchoolInfo.LocalId", O: "SL0"},
+		Triple{S: "Q1", P: "is-a", O: "Property.Link"},
+	)
+
+	toValue := Triple{S: "E1", P: "references", O: "L1"}
+	toObject := Triple{S: "E1", P: "references", O: "S0"}
+	untouched := Triple{S: "E1", P: "references", O: "Q1"}
+
+	tests := []struct {
+		name    string
+		links   []Triple
+		removed map[string]struct{}
+		current map[string]struct{}
+		want    []Triple
+	}{
+		{"nothing removed", []Triple{toValue, toObject}, valueSet(), valueSet(), []Triple{}},
+		{"value node lost", []Triple{toValue}, valueSet("L1"), valueSet(), []Triple{toValue}},
+		{"value node still held", []Triple{toValue}, valueSet("L1"), valueSet("L1"), []Triple{}},
+		{"object value lost", []Triple{toObject}, valueSet("SL0"), valueSet(), []Triple{toObject}},
+		{"object id still held", []Triple{toObject}, valueSet("SL0"), valueSet("S0"), []Triple{}},
+		{"object value still held", []Triple{toObject}, valueSet("SL0"), valueSet("SL0"), []Triple{}},
+		{"link untouched", []Triple{untouched}, valueSet("SL0"), valueSet(), []Triple{}},
+		{"only lost links", []Triple{toValue, toObject, untouched}, valueSet("L1"), valueSet("S0"), []Triple{toValue}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := staleLinks(db, tt.links, tt.removed, tt.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sortedTriples(got), sortedTriples(tt.want)) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestStaleEdges(t *testing.T) {
+
+	db := openTestDB(t)
+	storeTriples(t, db,
+		Triple{S: "L1", P: "is-a", O: "Property.Link"},
+		Triple{S: "S0", P: "is-a", O: "SchoolInfo"},
+		Triple{S: "S0", P: "SchoolInfo.LocalId", O: "SL0"},
+		Triple{S: "X1", P: "is-a", O: sameAsType},
+		Triple{S: "X1", P: "sameAs.0", O: "SL0"},
+		Triple{S: "X1", P: "sameAs.1", O: "L9"},
+	)
+
+	byValue := Triple{S: "E1", P: edgePrefix + "StudentSchoolEnrolment.LocalId", O: "L1"}
+	byObject := Triple{S: "E1", P: edgePrefix + "SchoolInfo.LocalId", O: "S0"}
+	incoming := Triple{S: "S0", P: edgePrefix + "SchoolInfo.LocalId", O: "E1"}
+	explicit := Triple{S: "E1", P: edgePrefix + explicitEdgeLabel, O: "L1"}
+	crosswalk := Triple{S: "E1", P: edgePrefix + sameAsEdgeLabel, O: "X1"}
+
+	enrolment := func(p, o string) Triple {
+		return Triple{S: "E1", P: "StudentSchoolEnrolment." + p, O: o}
+	}
+
+	tests := []struct {
+		name    string
+		edges   []Triple
+		removed map[string]struct{}
+		current []Triple
+		want    []Triple
+	}{
+		{"nothing removed", []Triple{byValue, byObject}, valueSet(), nil, []Triple{}},
+		{"value node lost", []Triple{byValue}, valueSet("L1"), nil, []Triple{byValue}},
+		{"value held under the label", []Triple{byValue}, valueSet("L1"), []Triple{enrolment("LocalId", "L1")}, []Triple{}},
+		{"value held under another label", []Triple{byValue}, valueSet("L1"), []Triple{enrolment("OtherId", "L1")}, []Triple{byValue}},
+		{"object value lost", []Triple{byObject}, valueSet("SL0"), nil, []Triple{byObject}},
+		{"object value held elsewhere", []Triple{byObject}, valueSet("SL0"), []Triple{enrolment("SchoolLocalId", "SL0")}, []Triple{}},
+		{"incoming edge lost", []Triple{incoming}, valueSet("SL0"), nil, []Triple{incoming}},
+		{"explicit edges kept", []Triple{explicit}, valueSet("L1"), nil, []Triple{}},
+		{"crosswalk, value lost", []Triple{crosswalk}, valueSet("SL0"), nil, []Triple{crosswalk}},
+		{"crosswalk, other value shared", []Triple{crosswalk}, valueSet("SL0"), []Triple{enrolment("OtherId", "L9")}, []Triple{}},
+		{"edge untouched", []Triple{byObject}, valueSet("L1"), nil, []Triple{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := staleEdges(db, "E1", tt.edges, tt.removed, tt.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sortedTriples(got), sortedTriples(tt.want)) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+}
@@ -0,0 +1,197 @@
+// patch.go
+
+package deep6
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+)
+
+//
+// returned if a patch would change the n3id of the object,
+// a patch can update an object but cannot move it.
+//
+var ErrPatchChangesId = errors.New("patch changes the n3id of the object")
+
+//
+// returned by PatchById if the patched object fails validation
+// against the schema of its classifier, unless the classifier
+// annotates invalid objects
+//
+var ErrInvalidObject = errors.New("object fails schema validation")
+
+//
+// Applies a partial update to the object with the given id.
+//
+// patch can be either a JSON Merge Patch (RFC 7396), a json object
+// e.g. {"StudentPersonal":{"MostRecent":{"YearLevel":{"Code":"9"}}}}
+// or a JSON Patch (RFC 6902), a json array of operations
+// e.g. [{"op":"replace","path":"/StudentPersonal/LocalId","value":"x"}]
+//
+// The patch is applied to the stored object, which is then
+// reclassified and stored as an update, so only the triples
+// and links affected by the patch are rewritten.
+//
+// Objects whose id is derived from their content (the hash n3id
+// strategy) cannot be patched, as any change to the object moves it
+// to a new id; ingest the new version of the object instead.
+//
+// Returns ErrNotFound if there is no object with the id,
+// ErrPatchChangesId if the patched object would have a different id,
+// or its id is derived from its content, and ErrInvalidObject if it
+// fails validation and its classifier does not annotate invalid
+// objects.
+//
+func (d6 *Deep6DB) PatchById(id string, patch []byte) (*IngestReport, error) {
+
+	defer timeTrack(time.Now(), "PatchById()")
+
+	stored, err := findById(id, d6.db)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot patch object: "+id)
+	}
+	// remove the metadata added by the classifier,
+	// it is derived again from the patched object
+	delete(stored, "is-a")
+	delete(stored, "unique")
+	delete(stored, schemaErrorsKey)
+
+	original, err := json.Marshal(stored)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal stored object:")
+	}
+	patched, err := applyPatch(original, patch)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot apply patch:")
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	cfg := d6.Classifiers.config()
+	igd, err := classifyObject(ctx, cfg.classifiers, patched)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot classify patched object:")
+	}
+	if igd.contentId {
+		return nil, errors.Wrap(ErrPatchChangesId, "id is derived from the content of the object")
+	}
+	if igd.N3id != id {
+		//
+		// objects with no id in their data are given a new
+		// nuid each time they are classified, so check whether
+		// the id actually comes from the data before rejecting;
+		// the stored values are all strings, so the id of an
+		// object whose id is its content hash cannot be checked
+		//
+		check, err := classifyObject(ctx, cfg.classifiers, original)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot classify stored object:")
+		}
+		if check.contentId {
+			return nil, errors.Wrap(ErrPatchChangesId, "id is derived from the content of the object")
+		}
+		if check.N3id == id {
+			return nil, ErrPatchChangesId
+		}
+		igd.N3id = id
+	}
+	errs, err := validateObject(igd)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot validate patched object:")
+	}
+	if len(errs) > 0 {
+		if onInvalidAction(igd.OnInvalid) != onInvalidAnnotate {
+			return nil, errors.Wrap(ErrInvalidObject, strings.Join(errs, "; "))
+		}
+		igd.SchemaErrors = errs
+		igd.RawData[schemaErrorsKey] = errs
+	}
+
+	report := newIngestReport()
+	defer report.finish()
+	report.ObjectsRead = 1
+	if len(igd.SchemaErrors) > 0 {
+		report.addInvalid(ValidationFailure{
+			N3id:      igd.N3id,
+			DataModel: igd.DataModel,
+			Action:    onInvalidAnnotate,
+			Errors:    igd.SchemaErrors,
+		})
+	}
+
+	c := make(chan IngestData, 1)
+	c <- igd
+	close(c)
+	env := &ingestEnv{db: d6.db, wb: d6.iwb, sbf: d6.sbf, cfg: cfg, workers: 1, report: report}
+	err = runStages(ctx, env, c, nil, d6.AuditLevel, d6.pipeline().classifiedStages())
+	// ensure the writer finishes
+	flushErr := d6.iwb.Flush()
+	// reinstate the writer
+	d6.iwb = d6.db.NewWriteBatch()
+	if err != nil {
+		return report, errors.Wrap(err, "error storing patched object:")
+	}
+	if flushErr != nil {
+		return report, errors.Wrap(flushErr, "error writing patched object:")
+	}
+
+	return report, nil
+
+}
+
+//
+// applies a merge patch or json patch to a json document,
+// the kind of patch is decided by whether it is a json
+// object or array.
+//
+func applyPatch(doc, patch []byte) ([]byte, error) {
+
+	trimmed := bytes.TrimSpace(patch)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		p, err := jsonpatch.DecodePatch(trimmed)
+		if err != nil {
+			return nil, err
+		}
+		return p.Apply(doc)
+	}
+
+	return jsonpatch.MergePatch(doc, trimmed)
+
+}
+
+//
+// runs a single json object through the object classifier.
+//
+func classifyObject(ctx context.Context, classifiers []*Classifier, obj []byte) (IngestData, error) {
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(obj, &m); err != nil {
+		return IngestData{}, err
+	}
+
+	in := make(chan IngestData, 1)
+	in <- IngestData{RawData: m}
+	close(in)
+
+	out, errc, err := objectClassifier(ctx, classifiers, nil, in)
+	if err != nil {
+		return IngestData{}, err
+	}
+	igd, ok := <-out
+	if err := <-errc; err != nil {
+		return IngestData{}, err
+	}
+	if !ok {
+		return IngestData{}, errors.New("object was not classified")
+	}
+
+	return igd, nil
+
+}
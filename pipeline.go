@@ -0,0 +1,464 @@
+// pipeline.go
+
+package deep6
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+)
+
+//
+// Names of the built-in stages of the ingest pipeline,
+// in the order they run by default.
+//
+const (
+	StageCollectionExpander = "collection-expander"
+	StageObjectClassifier   = "object-classifier"
+	StageSchemaValidator    = "schema-validator"
+	StageObjectRemover      = "object-remover"
+	StageXapiStatements     = "xapi-statements"
+	StageTupleGenerator     = "tuple-generator"
+	StageTripleWriter       = "triple-writer"
+	StageLinkParser         = "link-parser"
+	StageLinkDiffer         = "link-differ"
+	StageReverseLinkChecker = "reverse-link-checker"
+	StageLinkBuilder        = "link-builder"
+	StageLinkWriter         = "link-writer"
+)
+
+//
+// A stage of the ingest pipeline.
+//
+// Run starts the stage, which reads objects from in until it
+// is closed and passes them on to the channel it returns,
+// closing both of its channels when done. A stage must stop
+// when ctx is cancelled, and reports any error on its
+// error channel, which stops the ingest.
+//
+// Stages can change the objects they pass on, or drop them
+// by not passing them on; see TransformStage() for the
+// simple case of a change to each object.
+//
+type Stage interface {
+	// name of the stage, unique within a pipeline
+	Name() string
+	// starts the stage
+	Run(ctx context.Context, in <-chan IngestData) (
+		<-chan IngestData, // pass data on to the next stage
+		<-chan error, // emits errors encountered to the pipeline
+		error) // any error encountered when creating the stage
+}
+
+//
+// The stages that ingested objects pass through, in order.
+//
+// NewPipeline() gives the built-in stages, which applications
+// can add to, replace or reorder, e.g. to enrich objects or map
+// their ids between classification and tuple generation:
+//
+// p := deep6.NewPipeline()
+// err := p.InsertBefore(deep6.StageObjectRemover, mapIds)
+// db.Pipeline = p
+//
+// Objects arrive from the source as raw json and are classified by
+// the object-classifier stage. The stages from object-remover on
+// run as parallel lanes when the db has more than one ingest worker,
+// with objects sharded by n3id, so a stage among them is started
+// once for each lane and must not change the n3id of an object;
+// they also only see objects that have changed since they were last
+// ingested. Without an object-remover stage every stage runs as a
+// single chain.
+//
+// A pipeline should not be changed while an ingest is using it.
+// Dry runs use the pipeline too, with the built-in stages writing
+// nothing; a stage that writes to the database itself should check
+// IsDryRun() first.
+//
+type Pipeline struct {
+	stages []Stage
+}
+
+//
+// Creates a pipeline of the built-in stages.
+//
+func NewPipeline() *Pipeline {
+
+	p := &Pipeline{}
+	for _, b := range builtinStages {
+		p.stages = append(p.stages, b)
+	}
+	return p
+
+}
+
+//
+// Lists the names of the stages in the order they run.
+//
+func (p *Pipeline) Stages() []string {
+
+	names := make([]string, 0, len(p.stages))
+	for _, s := range p.stages {
+		names = append(names, s.Name())
+	}
+	return names
+
+}
+
+//
+// Adds a stage to the end of the pipeline.
+//
+func (p *Pipeline) Append(s Stage) error {
+
+	if err := p.checkNew(s); err != nil {
+		return err
+	}
+	p.stages = append(p.stages, s)
+	return nil
+
+}
+
+//
+// Adds a stage immediately before the named stage.
+//
+func (p *Pipeline) InsertBefore(name string, s Stage) error {
+	return p.insert(name, 0, s)
+}
+
+//
+// Adds a stage immediately after the named stage.
+//
+func (p *Pipeline) InsertAfter(name string, s Stage) error {
+	return p.insert(name, 1, s)
+}
+
+//
+// Replaces the named stage with another.
+//
+func (p *Pipeline) Replace(name string, s Stage) error {
+
+	i, err := p.find(name)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return errors.New("pipeline: stage is nil")
+	}
+	if s.Name() != name {
+		if err := p.checkNew(s); err != nil {
+			return err
+		}
+	}
+	p.stages[i] = s
+	return nil
+
+}
+
+//
+// Removes the named stage.
+//
+func (p *Pipeline) Remove(name string) error {
+
+	i, err := p.find(name)
+	if err != nil {
+		return err
+	}
+	p.stages = append(p.stages[:i], p.stages[i+1:]...)
+	return nil
+
+}
+
+//
+// Reorders the stages, names must list every
+// stage of the pipeline once.
+//
+func (p *Pipeline) Reorder(names ...string) error {
+
+	if len(names) != len(p.stages) {
+		return fmt.Errorf("pipeline: reorder lists %d stages, pipeline has %d", len(names), len(p.stages))
+	}
+	reordered := make([]Stage, 0, len(names))
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			return fmt.Errorf("pipeline: stage %s listed more than once", name)
+		}
+		seen[name] = struct{}{}
+		i, err := p.find(name)
+		if err != nil {
+			return err
+		}
+		reordered = append(reordered, p.stages[i])
+	}
+	p.stages = reordered
+	return nil
+
+}
+
+func (p *Pipeline) insert(name string, offset int, s Stage) error {
+
+	i, err := p.find(name)
+	if err != nil {
+		return err
+	}
+	if err := p.checkNew(s); err != nil {
+		return err
+	}
+	i += offset
+	p.stages = append(p.stages[:i], append([]Stage{s}, p.stages[i:]...)...)
+	return nil
+
+}
+
+//
+// returns the position of the named stage
+//
+func (p *Pipeline) find(name string) (int, error) {
+
+	for i, s := range p.stages {
+		if s.Name() == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("pipeline: no stage named %s", name)
+
+}
+
+//
+// checks that a stage can be added to the pipeline
+//
+func (p *Pipeline) checkNew(s Stage) error {
+
+	if s == nil {
+		return errors.New("pipeline: stage is nil")
+	}
+	if s.Name() == "" {
+		return errors.New("pipeline: stage has no name")
+	}
+	if _, err := p.find(s.Name()); err == nil {
+		return fmt.Errorf("pipeline: already has a stage named %s", s.Name())
+	}
+	return nil
+
+}
+
+//
+// returns a copy of the stages, so an ingest is
+// not affected by later changes to the pipeline
+//
+func (p *Pipeline) list() []Stage {
+	return append([]Stage(nil), p.stages...)
+}
+
+//
+// returns the stages that follow classification, used to store
+// an object classified outside the pipeline (e.g. a patched
+// object); the schema validator is left out as such objects
+// are validated by the caller
+//
+func (p *Pipeline) classifiedStages() []Stage {
+
+	stages := p.list()
+	if i, err := p.find(StageObjectClassifier); err == nil {
+		stages = stages[i+1:]
+	}
+	kept := make([]Stage, 0, len(stages))
+	for _, s := range stages {
+		if s.Name() != StageSchemaValidator {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+
+}
+
+//
+// returns the pipeline used by ingests
+//
+func (d6 *Deep6DB) pipeline() *Pipeline {
+
+	if d6.Pipeline == nil {
+		return NewPipeline()
+	}
+	return d6.Pipeline
+
+}
+
+//
+// Creates a stage from a name and a function that starts it.
+//
+func NewStage(name string, run func(ctx context.Context, in <-chan IngestData) (<-chan IngestData, <-chan error, error)) Stage {
+	return &funcStage{name: name, run: run}
+}
+
+//
+// Creates a stage that applies a function to each object,
+// the object returned is passed on unless keep is false.
+//
+// If the function returns an error the ingest stops, unless the
+// db is tolerant in which case the object is sent to the
+// dead-letter queue.
+//
+func TransformStage(name string, transform func(igd IngestData) (result IngestData, keep bool, err error)) Stage {
+	return NewStage(name, func(ctx context.Context, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+
+		out := make(chan IngestData)
+		errc := make(chan error, 1)
+
+		go func() {
+			defer close(out)
+			defer close(errc)
+
+			var dlq *deadLetterQueue
+			if env := ingestEnvFrom(ctx); env != nil {
+				dlq = env.dlq
+			}
+			clock := stageClockFrom(ctx)
+			for igd := range in {
+				start := time.Now()
+				result, keep, err := transform(igd)
+				if err != nil {
+					err = errors.Wrapf(err, "%s:", name)
+					raw, _ := json.Marshal(objectData(igd))
+					if !dlq.reject(igd.Position, -1, raw, name, err) {
+						errc <- err
+						return
+					}
+					clock.add(start)
+					continue
+				}
+				if !keep {
+					clock.add(start)
+					continue
+				}
+				clock.add(start)
+				select {
+				case out <- result: // pass the data on to the next stage
+				case <-ctx.Done(): // listen for pipeline shutdown
+					return
+				}
+			}
+		}()
+
+		return out, errc, nil
+
+	})
+}
+
+type funcStage struct {
+	name string
+	run  func(ctx context.Context, in <-chan IngestData) (<-chan IngestData, <-chan error, error)
+}
+
+func (s *funcStage) Name() string {
+	return s.name
+}
+
+func (s *funcStage) Run(ctx context.Context, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+	return s.run(ctx, in)
+}
+
+//
+// The resources of a running ingest, carried in its
+// context so that the built-in stages can reach them.
+//
+// Objects only see those earlier in their stream once wb has
+// committed them, so the labelled edges of links are written
+// with a writer of their own, ewb, so that they do not change
+// when wb commits, and so which objects are linked.
+//
+type ingestEnv struct {
+	db      *badger.DB
+	wb      *badger.WriteBatch
+	ewb     *badger.WriteBatch
+	sbf     *linkFilter
+	cfg     *classifierConfig
+	workers int
+	report  *IngestReport
+	dlq     *deadLetterQueue
+	dryRun  bool
+}
+
+type ingestEnvKey struct{}
+
+//
+// returns the environment of the ingest running
+// under ctx, nil if there is none
+//
+func ingestEnvFrom(ctx context.Context) *ingestEnv {
+	env, _ := ctx.Value(ingestEnvKey{}).(*ingestEnv)
+	return env
+}
+
+//
+// A built-in stage, which runs using the
+// resources of the ingest it is part of.
+//
+type builtinStage struct {
+	name string
+	run  func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error)
+}
+
+func (s *builtinStage) Name() string {
+	return s.name
+}
+
+func (s *builtinStage) Run(ctx context.Context, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+
+	env := ingestEnvFrom(ctx)
+	if env == nil {
+		return nil, nil, fmt.Errorf("stage %s can only run as part of an ingest", s.name)
+	}
+	return s.run(ctx, env, in)
+
+}
+
+//
+// the built-in stages, in their default order
+//
+var builtinStages = []*builtinStage{
+	{StageCollectionExpander, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return collectionExpander(ctx, env.cfg.collections, in)
+	}},
+	{StageObjectClassifier, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		out, errcs, err := parallelClassifier(ctx, env.cfg.classifiers, env.workers, env.dlq, in)
+		if err != nil {
+			return nil, nil, err
+		}
+		return out, MergeErrors(errcs...), nil
+	}},
+	{StageSchemaValidator, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return schemaValidator(ctx, env.dlq, env.report, false, in)
+	}},
+	{StageObjectRemover, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return objectRemover(ctx, env.db, in)
+	}},
+	{StageXapiStatements, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return xapiStatements(ctx, env.wb, in)
+	}},
+	{StageTupleGenerator, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return tupleGenerator(ctx, in)
+	}},
+	{StageTripleWriter, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return tripleWriter(ctx, env.wb, in)
+	}},
+	{StageLinkParser, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return linkParser(ctx, env.sbf, in)
+	}},
+	{StageLinkDiffer, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return linkDiffer(ctx, env.db, env.sbf, in)
+	}},
+	{StageReverseLinkChecker, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return linkReverseChecker(ctx, env.db, env.wb, env.cfg.links, in)
+	}},
+	{StageLinkBuilder, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return linkBuilder(ctx, env.db, env.wb, in)
+	}},
+	{StageLinkWriter, func(ctx context.Context, env *ingestEnv, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		return linkWriter(ctx, env.wb, env.ewb, in)
+	}},
+}
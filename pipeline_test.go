@@ -0,0 +1,131 @@
+// pipeline_test.go
+
+package deep6
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPipelineEdits(t *testing.T) {
+
+	stage := func(name string) Stage {
+		return TransformStage(name, func(igd IngestData) (IngestData, bool, error) {
+			return igd, true, nil
+		})
+	}
+	builtin := strings.Join(NewPipeline().Stages(), ",")
+
+	tests := []struct {
+		name    string
+		edit    func(p *Pipeline) error
+		want    string // stages after the edit, empty if unchanged
+		wantErr string
+	}{
+		{"insert before", func(p *Pipeline) error {
+			return p.InsertBefore(StageObjectRemover, stage("map-ids"))
+		}, "schema-validator,map-ids,object-remover", ""},
+		{"insert after", func(p *Pipeline) error {
+			return p.InsertAfter(StageObjectClassifier, stage("enrich"))
+		}, "object-classifier,enrich,schema-validator", ""},
+		{"insert after the last stage", func(p *Pipeline) error {
+			return p.InsertAfter(StageLinkWriter, stage("notify"))
+		}, "link-writer,notify", ""},
+		{"append", func(p *Pipeline) error {
+			return p.Append(stage("notify"))
+		}, "link-writer,notify", ""},
+		{"insert at a missing stage", func(p *Pipeline) error {
+			return p.InsertBefore("no-such-stage", stage("enrich"))
+		}, "", "no stage named no-such-stage"},
+		{"insert a duplicate name", func(p *Pipeline) error {
+			return p.InsertAfter(StageTupleGenerator, stage(StageLinkParser))
+		}, "", "already has a stage named link-parser"},
+		{"insert a nil stage", func(p *Pipeline) error {
+			return p.InsertBefore(StageTupleGenerator, nil)
+		}, "", "stage is nil"},
+		{"insert an unnamed stage", func(p *Pipeline) error {
+			return p.Append(stage(""))
+		}, "", "stage has no name"},
+		{"replace keeping the name", func(p *Pipeline) error {
+			return p.Replace(StageSchemaValidator, stage(StageSchemaValidator))
+		}, builtin, ""},
+		{"replace with a new name", func(p *Pipeline) error {
+			return p.Replace(StageSchemaValidator, stage("strict-validator"))
+		}, "object-classifier,strict-validator,object-remover", ""},
+		{"replace with a name in use", func(p *Pipeline) error {
+			return p.Replace(StageSchemaValidator, stage(StageLinkParser))
+		}, "", "already has a stage named link-parser"},
+		{"replace a missing stage", func(p *Pipeline) error {
+			return p.Replace("no-such-stage", stage("x"))
+		}, "", "no stage named no-such-stage"},
+		{"remove", func(p *Pipeline) error {
+			return p.Remove(StageSchemaValidator)
+		}, "object-classifier,object-remover", ""},
+		{"reorder", func(p *Pipeline) error {
+			names := NewPipeline().Stages()
+			names[0], names[1] = names[1], names[0]
+			return p.Reorder(names...)
+		}, "object-classifier,collection-expander,schema-validator", ""},
+		{"reorder missing a stage", func(p *Pipeline) error {
+			return p.Reorder(NewPipeline().Stages()[1:]...)
+		}, "", "reorder lists 11 stages, pipeline has 12"},
+		{"reorder repeating a stage", func(p *Pipeline) error {
+			names := NewPipeline().Stages()
+			names[1] = names[0]
+			return p.Reorder(names...)
+		}, "", "listed more than once"},
+		{"reorder naming a missing stage", func(p *Pipeline) error {
+			names := NewPipeline().Stages()
+			names[1] = "no-such-stage"
+			return p.Reorder(names...)
+		}, "", "no stage named no-such-stage"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPipeline()
+			err := tt.edit(p)
+			got := strings.Join(p.Stages(), ",")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				if got != builtin {
+					t.Errorf("failed edit changed the pipeline to %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("got %s, want it to contain %s", got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestPipelineIsolation(t *testing.T) {
+
+	// each pipeline has its own copy of the built-in stages
+	a, b := NewPipeline(), NewPipeline()
+	if err := a.Remove(StageLinkDiffer); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strings.Join(b.Stages(), ","), StageLinkDiffer) == false {
+		t.Errorf("removing a stage from one pipeline changed another")
+	}
+
+	// an ingest runs with the stages it started with
+	stages := a.list()
+	if err := a.Append(TransformStage("late", func(igd IngestData) (IngestData, bool, error) {
+		return igd, true, nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	if len(stages) != len(a.Stages())-1 {
+		t.Errorf("list() has %d stages, want %d", len(stages), len(a.Stages())-1)
+	}
+
+}
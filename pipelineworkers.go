@@ -0,0 +1,316 @@
+// pipelineworkers.go
+
+package deep6
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+//
+// signature shared by the pipeline stages that consume
+// and emit IngestData, used to assemble the stages into
+// lanes that can be run in parallel.
+//
+type ingestStage func(ctx context.Context, in <-chan IngestData) (
+	<-chan IngestData, // pass on to next stage
+	<-chan error, // emits errors encountered to the pipeline
+	error) // returns any error encountered creating the stage
+
+//
+// Fans object classification out over a pool of classifiers.
+//
+// Objects are dealt out to the classifiers in turn, and their
+// output put back into the order the objects arrived (see
+// inArrivalOrder()), so two versions of an object reach the
+// lanes, and so are stored, in the order they were read.
+//
+// ctx - pipeline management context
+// classifiers - the classifiers to use, in the order they are checked
+// workers - number of classifiers to run
+// dlq - dead-letter queue for objects that cannot be classified
+// in - channel providing json objects from the source
+//
+func parallelClassifier(ctx context.Context, classifiers []*Classifier, workers int, dlq *deadLetterQueue, in <-chan IngestData) (
+	<-chan IngestData, // output of all classifiers, in arrival order
+	[]<-chan error, // error channels of all classifiers
+	error) { // any error encountered creating the classifiers
+
+	if workers < 2 {
+		out, errc, err := objectClassifier(ctx, classifiers, dlq, in)
+		return out, []<-chan error{errc}, err
+	}
+
+	ins := make([]chan IngestData, 0, workers)
+	outs := make([]<-chan IngestData, 0, workers)
+	errcs := make([]<-chan error, 0, workers)
+	for i := 0; i < workers; i++ {
+		c := make(chan IngestData)
+		out, errc, err := objectClassifier(ctx, classifiers, dlq, c)
+		if err != nil {
+			return nil, nil, err
+		}
+		ins = append(ins, c)
+		outs = append(outs, out)
+		errcs = append(errcs, errc)
+	}
+
+	// number the objects and deal them out in turn
+	go func() {
+		defer func() {
+			for _, c := range ins {
+				close(c)
+			}
+		}()
+		seq := 0
+		for igd := range in {
+			igd.seq = seq
+			select {
+			case ins[seq%workers] <- igd: // pass the data on to its classifier
+			case <-ctx.Done(): // listen for pipeline shutdown
+				return
+			}
+			seq++
+		}
+	}()
+
+	return inArrivalOrder(ctx, outs), errcs, nil
+
+}
+
+//
+// merges the output of workers that were dealt objects in turn
+// back into the order the objects were numbered
+//
+// Each worker's output is in order, so a number skipped by the
+// worker it was dealt to is an object the worker dropped (e.g. a
+// dead letter) and is not waited for. Output of every worker is read
+// as it arrives and held until its turn, so a worker is never left
+// blocked waiting for another.
+//
+func inArrivalOrder(ctx context.Context, outs []<-chan IngestData) <-chan IngestData {
+
+	type result struct {
+		worker int
+		igd    IngestData
+		closed bool
+	}
+
+	results := make(chan result)
+	for i, c := range outs {
+		go func(worker int, c <-chan IngestData) {
+			for igd := range c {
+				select {
+				case results <- result{worker: worker, igd: igd}:
+				case <-ctx.Done(): // listen for pipeline shutdown
+					return
+				}
+			}
+			select {
+			case results <- result{worker: worker, closed: true}:
+			case <-ctx.Done():
+			}
+		}(i, c)
+	}
+
+	out := make(chan IngestData)
+	go func() {
+		defer close(out)
+
+		n := len(outs)
+		held := make([][]IngestData, n) // output waiting for its turn, per worker
+		closed := make([]bool, n)
+		open, waiting, next := n, 0, 0
+		for open > 0 || waiting > 0 {
+			if open > 0 {
+				var r result
+				select {
+				case r = <-results:
+				case <-ctx.Done(): // listen for pipeline shutdown
+					return
+				}
+				if r.closed {
+					closed[r.worker] = true
+					open--
+				} else {
+					held[r.worker] = append(held[r.worker], r.igd)
+					waiting++
+				}
+			}
+			// send on everything whose turn has come
+			for {
+				w := next % n
+				if len(held[w]) == 0 {
+					if !closed[w] || waiting == 0 {
+						break // wait for the worker
+					}
+					next++ // the worker has finished, the object was dropped
+					continue
+				}
+				igd := held[w][0]
+				if igd.seq == next {
+					select {
+					case out <- igd: // pass the data on to the next stage
+					case <-ctx.Done(): // listen for pipeline shutdown
+						return
+					}
+					held[w] = held[w][1:]
+					waiting--
+				}
+				next++
+			}
+		}
+	}()
+
+	return out
+
+}
+
+//
+// Runs the given sequence of stages as a set of parallel lanes.
+//
+// Objects are sharded across the lanes by n3id, so every version of
+// an object that appears in the stream passes through the same lane
+// in the order it arrived; e.g. the removal of an existing object
+// always happens before its replacement is written, and two
+// versions of the same object can never be written concurrently.
+//
+// Output of all lanes is merged into a single channel, objects
+// with different n3ids can arrive in any order.
+//
+// ctx - pipeline management context
+// workers - number of lanes to run, 1 runs the stages as a simple chain
+// in - channel providing IngestData objects
+// stages - the stages that make up each lane, in order
+//
+func parallelLanes(ctx context.Context, workers int, in <-chan IngestData, stages ...ingestStage) (
+	<-chan IngestData, // merged output of all lanes
+	[]<-chan error, // error channels of every stage in every lane
+	error) { // any error encountered creating the stages
+
+	shards := []<-chan IngestData{in}
+	if workers > 1 {
+		shards = shardByID(ctx, workers, in)
+	}
+
+	outs := make([]<-chan IngestData, 0, len(shards))
+	errcs := make([]<-chan error, 0, len(shards)*len(stages))
+	for _, shard := range shards {
+		head := shard
+		for _, stage := range stages {
+			out, errc, err := stage(ctx, head)
+			if err != nil {
+				return nil, nil, err
+			}
+			errcs = append(errcs, errc)
+			head = out
+		}
+		outs = append(outs, head)
+	}
+
+	if len(outs) == 1 {
+		return outs[0], errcs, nil
+	}
+	return mergeIngestData(ctx, outs...), errcs, nil
+
+}
+
+//
+// wraps an ingest stage so that objects marked as unchanged
+// go around it, the stage only sees objects it has work to do on.
+//
+func skipUnchanged(stage ingestStage) ingestStage {
+	return func(ctx context.Context, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+
+		work := make(chan IngestData)
+		skipped := make(chan IngestData)
+		go func() {
+			defer close(work)
+			defer close(skipped)
+			for igd := range in {
+				next := work
+				if igd.Unchanged {
+					next = skipped
+				}
+				select {
+				case next <- igd: // pass the data on to the stage or around it
+				case <-ctx.Done(): // listen for pipeline shutdown
+					return
+				}
+			}
+		}()
+
+		out, errc, err := stage(ctx, work)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mergeIngestData(ctx, out, skipped), errc, nil
+
+	}
+}
+
+//
+// distributes objects over n channels using a hash of their n3id
+//
+func shardByID(ctx context.Context, n int, in <-chan IngestData) []<-chan IngestData {
+
+	shards := make([]chan IngestData, n)
+	outs := make([]<-chan IngestData, n)
+	for i := range shards {
+		shards[i] = make(chan IngestData)
+		outs[i] = shards[i]
+	}
+
+	go func() {
+		defer func() {
+			for _, shard := range shards {
+				close(shard)
+			}
+		}()
+		for igd := range in {
+			h := fnv.New32a()
+			h.Write([]byte(igd.N3id))
+			select {
+			case shards[h.Sum32()%uint32(n)] <- igd: // pass the data on to its lane
+			case <-ctx.Done(): // listen for pipeline shutdown
+				return
+			}
+		}
+	}()
+
+	return outs
+
+}
+
+//
+// merges multiple IngestData channels into one,
+// output is closed once all inputs are closed.
+//
+func mergeIngestData(ctx context.Context, cs ...<-chan IngestData) <-chan IngestData {
+
+	var wg sync.WaitGroup
+	out := make(chan IngestData)
+
+	output := func(c <-chan IngestData) {
+		defer wg.Done()
+		for igd := range c {
+			select {
+			case out <- igd: // pass the data on to the next stage
+			case <-ctx.Done(): // listen for pipeline shutdown
+				return
+			}
+		}
+	}
+	wg.Add(len(cs))
+	for _, c := range cs {
+		go output(c)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+
+}
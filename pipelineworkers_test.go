@@ -0,0 +1,72 @@
+// pipelineworkers_test.go
+
+package deep6
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestInArrivalOrder(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		workers int
+		total   int
+		dropped func(seq int) bool
+	}{
+		{"nothing dropped", 4, 200, func(int) bool { return false }},
+		{"some dropped", 4, 200, func(seq int) bool { return seq%7 == 3 }},
+		{"one worker drops everything", 3, 90, func(seq int) bool { return seq%3 == 1 }},
+		{"everything dropped", 2, 10, func(int) bool { return true }},
+		{"more workers than objects", 8, 3, func(int) bool { return false }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			outs := make([]<-chan IngestData, tt.workers)
+			for w := 0; w < tt.workers; w++ {
+				c := make(chan IngestData)
+				outs[w] = c
+				go func(w int, c chan<- IngestData) {
+					defer close(c)
+					for seq := w; seq < tt.total; seq += tt.workers {
+						time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+						if tt.dropped(seq) {
+							continue
+						}
+						c <- IngestData{seq: seq}
+					}
+				}(w, c)
+			}
+
+			want := make([]int, 0)
+			for seq := 0; seq < tt.total; seq++ {
+				if !tt.dropped(seq) {
+					want = append(want, seq)
+				}
+			}
+			got := make([]int, 0)
+			for igd := range inArrivalOrder(ctx, outs) {
+				got = append(got, igd.seq)
+			}
+			if ctx.Err() != nil {
+				t.Fatal("timed out waiting for output")
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got %d objects, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("object %d: got seq %d, want %d", i, got[i], want[i])
+				}
+			}
+		})
+	}
+
+}
@@ -54,7 +54,7 @@ func findById(id string, db *badger.DB) (map[string]interface{}, error) {
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
 			t := NewTriple(string(item.Key()))
-			if t.O != "Property.Link" { // don't return as part of object data
+			if t.O != "Property.Link" && !isNormalised(t.P) { // don't return as part of object data
 				jsonDoc, _ = sjson.SetBytes(jsonDoc, t.P, t.O)
 				matches++
 			}
@@ -98,11 +98,11 @@ func (d6 *Deep6DB) FindByType(typename string, filterspec FilterSpec) (map[strin
 
 	defer timeTrack(time.Now(), "FindByType()")
 
-	return findByType(typename, filterspec, d6.db)
+	return findByType(typename, filterspec, d6.db, d6.IncludeVoided)
 
 }
 
-func findByType(typename string, filterspec FilterSpec, db *badger.DB) (map[string][]map[string]interface{}, error) {
+func findByType(typename string, filterspec FilterSpec, db *badger.DB, includeVoided bool) (map[string][]map[string]interface{}, error) {
 
 	results := make([]map[string]interface{}, 0)
 	targets := make([]string, 0)
@@ -122,6 +122,12 @@ func findByType(typename string, filterspec FilterSpec, db *badger.DB) (map[stri
 	if err != nil {
 		return nil, err
 	}
+	if !includeVoided {
+		targets, err = withoutVoided(db, targets)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	for _, target := range targets {
 		result, err := findById(target, db)
@@ -150,10 +156,10 @@ func (d6 *Deep6DB) FindByValue(term string, filterspec FilterSpec) (map[string][
 
 	defer timeTrack(time.Now(), "FindByValue()")
 
-	return findByValue(term, filterspec, d6.db)
+	return findByValue(term, filterspec, d6.db, d6.IncludeVoided)
 }
 
-func findByValue(term string, filterspec FilterSpec, db *badger.DB) (map[string][]map[string]interface{}, error) {
+func findByValue(term string, filterspec FilterSpec, db *badger.DB, includeVoided bool) (map[string][]map[string]interface{}, error) {
 
 	results := make([]map[string]interface{}, 0)
 	targets := make(map[string]interface{}, 0)
@@ -173,8 +179,18 @@ func findByValue(term string, filterspec FilterSpec, db *badger.DB) (map[string]
 	if err != nil {
 		return nil, err
 	}
+	ids := make([]string, 0, len(targets))
+	for target := range targets {
+		ids = append(ids, target)
+	}
+	if !includeVoided {
+		ids, err = withoutVoided(db, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	for target, _ := range targets {
+	for _, target := range ids {
 		result, err := findById(target, db)
 		if err != nil {
 			return nil, err
@@ -211,10 +227,10 @@ func (d6 *Deep6DB) FindByPredicate(predicate string, filterspec FilterSpec) (map
 
 	defer timeTrack(time.Now(), "FindByPredicate()")
 
-	return findByPredicate(predicate, filterspec, d6.db)
+	return findByPredicate(predicate, filterspec, d6.db, d6.IncludeVoided)
 }
 
-func findByPredicate(predicate string, filterspec FilterSpec, db *badger.DB) (map[string][]map[string]interface{}, error) {
+func findByPredicate(predicate string, filterspec FilterSpec, db *badger.DB, includeVoided bool) (map[string][]map[string]interface{}, error) {
 
 	results := make([]map[string]interface{}, 0)
 	targets := make(map[string]interface{}, 0) // use a map here to de-dupe, so user can pass part predicate
@@ -235,8 +251,18 @@ func findByPredicate(predicate string, filterspec FilterSpec, db *badger.DB) (ma
 	if err != nil {
 		return nil, err
 	}
+	ids := make([]string, 0, len(targets))
+	for target := range targets {
+		ids = append(ids, target)
+	}
+	if !includeVoided {
+		ids, err = withoutVoided(db, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	for target, _ := range targets {
+	for _, target := range ids {
 		result, err := findById(target, db)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,62 @@
+// readersource.go
+
+package deep6
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"unicode"
+)
+
+//
+// Source stage for any reader based ingest.
+//
+// Inspects the start of the stream to decide whether it
+// carries json or xml data and hands the stream to the
+// matching source; json arrays go to the json reader,
+// anything starting with '<' is treated as (SIF) xml.
+//
+// ctx - required context for pipeline management
+// r - reader accessing json or xml data
+// rules - the collection rules, used to recognise xml envelopes
+// dlq - dead-letter queue for bad objects, nil if bad
+// objects should stop the pipeline
+//
+func readerSource(ctx context.Context, r io.Reader, rules []collectionRule, dlq *deadLetterQueue) (
+	<-chan IngestData, // source emits json objects read from stream as map in IngestData
+	<-chan error, // emits any errors encountered to the pipeline
+	error) { // any error when creating the source stage itself
+
+	br := bufio.NewReader(r)
+	if isXMLStream(br) {
+		return xmlReaderSource(ctx, br, rules, dlq)
+	}
+	return jsonReaderSource(ctx, br, dlq)
+
+}
+
+//
+// peeks at the first significant character of the
+// stream, without consuming it, to see if it is xml.
+//
+func isXMLStream(br *bufio.Reader) bool {
+
+	n := 1
+	if bom, _ := br.Peek(3); string(bom) == "\xEF\xBB\xBF" { // utf-8 byte order mark
+		n = 4
+	}
+	for ; n <= br.Size(); n++ {
+		b, _ := br.Peek(n)
+		if len(b) < n {
+			return false // end of stream
+		}
+		c := rune(b[n-1])
+		if unicode.IsSpace(c) {
+			continue
+		}
+		return c == '<'
+	}
+	return false
+
+}
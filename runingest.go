@@ -6,7 +6,6 @@ import (
 
 	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
-	boom "github.com/tylertreat/BoomFilters"
 )
 
 //
@@ -18,88 +17,34 @@ import (
 // eg. db/wb are used here for loading data
 // but db can also be in use to support queries in parallel.
 //
+// parent - context of the caller, cancelling it stops the ingest
 // db - instance of a badger db
 // wb - badger.WriteBatch, a fast write manager provided by the db
 // sbf - bloom filter used to capture required graph links as data traverses the pipeline
-// r - the io.Reader (file, http body etc.) to be ingested
+// r - the io.Reader (file, http body etc.) to be ingested, json or SIF xml
 // auditLevel - one of: none, basic, high
+// classifiers - registry of the classifiers used to identify objects
+// pipeline - the stages objects pass through (see pipeline.go)
+// workers - number of parallel workers for the expensive stages, see runIngestPipeline()
+// report - receives the outcome of the ingest
+// dlq - dead-letter queue for bad objects in a tolerant ingest,
+// nil if bad objects should stop the ingest
 //
-func runIngestWithReader(db *badger.DB, wb *badger.WriteBatch, sbf *boom.ScalableBloomFilter, r io.Reader, auditLevel, folderPath string) error {
+func runIngestWithReader(parent context.Context, db *badger.DB, wb *badger.WriteBatch, sbf *linkFilter, r io.Reader, auditLevel string, classifiers *ClassifierRegistry, pipeline *Pipeline, workers int, report *IngestReport, dlq *deadLetterQueue) error {
 
 	// set up a context to manage ingest pipeline
-	ctx, cancelFunc := context.WithCancel(context.Background())
+	ctx, cancelFunc := context.WithCancel(parent)
 	defer cancelFunc()
 
-	// monitor all error channels
-	var errcList []<-chan error
-
 	//
 	// build the pipleine by connecting all stages
 	//
-	jsonOut, errc, err := jsonReaderSource(ctx, r)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create json-reader source component: ")
-	}
-	errcList = append(errcList, errc)
-
-	classOut, errc, err := objectClassifier(ctx, folderPath, jsonOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create object-classifier component: ")
-	}
-	errcList = append(errcList, errc)
-
-	remObjOut, errc, err := objectRemover(ctx, db, wb, sbf, auditLevel, folderPath, classOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create object-remover component: ")
-	}
-	errcList = append(errcList, errc)
-
-	genOut, errc, err := tupleGenerator(ctx, remObjOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create tuple-generator component: ")
-	}
-	errcList = append(errcList, errc)
-
-	writerOut, errc, err := tripleWriter(ctx, wb, genOut)
+	jsonOut, errc, err := readerSource(ctx, r, classifiers.config().collections, dlq)
 	if err != nil {
-		return errors.Wrap(err, "Error: cannot create triple-writer component: ")
+		return errors.Wrap(err, "Error: cannot create reader source component: ")
 	}
-	errcList = append(errcList, errc)
 
-	linkerOut, errc, err := linkParser(ctx, sbf, writerOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create link-parser component: ")
-	}
-	errcList = append(errcList, errc)
-
-	reverselinkerOut, errc, err := linkReverseChecker(ctx, db, linkerOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create reverse-link-checker component: ")
-	}
-	errcList = append(errcList, errc)
-
-	builderOut, errc, err := linkBuilder(ctx, db, wb, reverselinkerOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create link-builder component: ")
-	}
-	errcList = append(errcList, errc)
-
-	lwriterOut, errc, err := linkWriter(ctx, wb, builderOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create link-writer component: ")
-	}
-	errcList = append(errcList, errc)
-
-	errc, err = ingestAuditSink(ctx, auditLevel, lwriterOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create audit-sink component: ")
-	}
-	errcList = append(errcList, errc)
-
-	// monitor progress
-	err = WaitForPipeline(errcList...)
-
-	return err
+	return runIngestPipeline(ctx, db, wb, sbf, jsonOut, errc, auditLevel, classifiers, pipeline, workers, report, dlq)
 
 }
 
@@ -107,81 +52,185 @@ func runIngestWithReader(db *badger.DB, wb *badger.WriteBatch, sbf *boom.Scalabl
 // same behaviour as run from reader, source here is a channel
 // iterator providing json objects as []bytes
 //
-func runIngestWithIterator(db *badger.DB, wb *badger.WriteBatch, sbf *boom.ScalableBloomFilter, c <-chan []byte, auditLevel, folderPath string) error {
+func runIngestWithIterator(parent context.Context, db *badger.DB, wb *badger.WriteBatch, sbf *linkFilter, c <-chan []byte, auditLevel string, classifiers *ClassifierRegistry, pipeline *Pipeline, workers int, report *IngestReport, dlq *deadLetterQueue) error {
 
 	// set up a context to manage ingest pipeline
-	ctx, cancelFunc := context.WithCancel(context.Background())
+	ctx, cancelFunc := context.WithCancel(parent)
 	defer cancelFunc()
 
-	// monitor all error channels
-	var errcList []<-chan error
-
 	//
 	// build the pipleine by connecting all stages
 	//
-	jsonOut, errc, err := jsonIteratorSource(ctx, c)
+	jsonOut, errc, err := jsonIteratorSource(ctx, c, dlq)
 	if err != nil {
 		return errors.Wrap(err, "Error: cannot create json-reader source component: ")
 	}
-	errcList = append(errcList, errc)
 
-	classOut, errc, err := objectClassifier(ctx, folderPath, jsonOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create object-classifier component: ")
-	}
-	errcList = append(errcList, errc)
+	return runIngestPipeline(ctx, db, wb, sbf, jsonOut, errc, auditLevel, classifiers, pipeline, workers, report, dlq)
 
-	remObjOut, errc, err := objectRemover(ctx, db, wb, sbf, auditLevel, folderPath, classOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create object-remover component: ")
-	}
-	errcList = append(errcList, errc)
+}
 
-	genOut, errc, err := tupleGenerator(ctx, remObjOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create tuple-generator component: ")
+//
+// Connects the ingest stages to the output of a source and
+// runs the pipeline until the source is exhausted.
+//
+// With more than one worker the classifier runs as a pool,
+// and the stages from the object remover on run as parallel lanes
+// sharded by n3id (see parallelLanes()), so all versions of an object
+// are still removed/written/linked in the order they arrived.
+//
+// ctx - context managing the pipeline
+// source - json objects emitted by the source stage
+// sourceErrc - error channel of the source stage
+// auditLevel - audit output is only produced if not 'none'
+// classifiers - registry of the classifiers, a snapshot is
+// taken when the pipeline starts
+// pipeline - the stages to run (see pipeline.go)
+// workers - number of parallel workers, < 2 runs every stage
+// as a single goroutine
+// report - receives counts and stage timings of the ingest
+// dlq - dead-letter queue for objects that cannot be classified,
+// or that are quarantined by schema validation
+//
+func runIngestPipeline(ctx context.Context, db *badger.DB, wb *badger.WriteBatch, sbf *linkFilter,
+	source <-chan IngestData, sourceErrc <-chan error, auditLevel string, classifiers *ClassifierRegistry, pipeline *Pipeline, workers int, report *IngestReport, dlq *deadLetterQueue) error {
+
+	// monitor all error channels
+	errcList := []<-chan error{report.watch("source", sourceErrc)}
+
+	// objects are counted as the source emits them, whichever
+	// stages follow, so a json collection counts as one object
+	source = report.countSource(ctx, source)
+
+	// the classifiers in use when the pipeline starts are used
+	// throughout, even if the registry changes during the ingest
+	env := &ingestEnv{
+		db:      db,
+		wb:      wb,
+		sbf:     sbf,
+		cfg:     classifiers.config(),
+		workers: workers,
+		report:  report,
+		dlq:     dlq,
 	}
-	errcList = append(errcList, errc)
 
-	writerOut, errc, err := tripleWriter(ctx, wb, genOut)
+	return runStages(ctx, env, source, errcList, auditLevel, pipeline.list())
+
+}
+
+//
+// Connects the given stages and runs them until their input
+// is exhausted, followed by the audit (if any) and the report.
+// Also used to store objects that have been classified outside
+// the pipeline (e.g. a patched object).
+//
+// Stages before the object remover run as a single chain, it and
+// the stages after it as parallel lanes, and stages after it only
+// see objects that have changed.
+//
+// env - resources of the ingest, made available to the stages
+// in - objects for the first stage
+// errcList - error channels of the upstream stages, monitored
+// along with those of the stages created here
+// stages - the stages to run, in order
+//
+func runStages(ctx context.Context, env *ingestEnv, in <-chan IngestData, errcList []<-chan error, auditLevel string, stages []Stage) error {
+
+	// the edges written by the stages have a writer of their
+	// own (see ingestEnv), flushed once the stages are done
+	env.ewb = env.db.NewWriteBatch()
+	err := connectStages(ctx, env, in, errcList, auditLevel, stages)
+	ferr := env.ewb.Flush()
 	if err != nil {
-		return errors.Wrap(err, "Error: cannot create triple-writer component: ")
+		return err
 	}
-	errcList = append(errcList, errc)
+	if ferr != nil {
+		return errors.Wrap(ferr, "error flushing edge writer:")
+	}
+	return nil
 
-	linkerOut, errc, err := linkParser(ctx, sbf, writerOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create link-parser component: ")
+}
+
+//
+// connects the stages as runStages() describes and
+// waits for them to finish
+//
+func connectStages(ctx context.Context, env *ingestEnv, in <-chan IngestData, errcList []<-chan error, auditLevel string, stages []Stage) error {
+
+	ctx = context.WithValue(ctx, ingestEnvKey{}, env)
+
+	lane := len(stages)
+	for i, s := range stages {
+		if s.Name() == StageObjectRemover {
+			lane = i
+			break
+		}
 	}
-	errcList = append(errcList, errc)
 
-	reverselinkerOut, errc, err := linkReverseChecker(ctx, db, linkerOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create reverse-link-checker component: ")
+	head := in
+	for _, s := range stages[:lane] {
+		out, errc, err := s.Run(env.report.timeStage(ctx, s.Name()), head)
+		if err != nil {
+			return errors.Wrapf(err, "Error: cannot create %s component: ", s.Name())
+		}
+		errcList = append(errcList, env.report.watch(s.Name(), errc))
+		head = out
 	}
-	errcList = append(errcList, errc)
 
-	builderOut, errc, err := linkBuilder(ctx, db, wb, reverselinkerOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create link-builder component: ")
+	if lane < len(stages) {
+		laneStages := make([]ingestStage, 0, len(stages)-lane)
+		for i, s := range stages[lane:] {
+			stage := env.report.timed(s.Name(), laneStage(s))
+			if i > 0 {
+				stage = skipUnchanged(stage)
+			}
+			laneStages = append(laneStages, stage)
+		}
+		lanesOut, errcs, err := parallelLanes(ctx, env.workers, head, laneStages...)
+		if err != nil {
+			return err
+		}
+		errcList = append(errcList, errcs...)
+		head = lanesOut
 	}
-	errcList = append(errcList, errc)
 
-	lwriterOut, errc, err := linkWriter(ctx, wb, builderOut)
-	if err != nil {
-		return errors.Wrap(err, "Error: cannot create link-writer component: ")
+	// audit output is optional
+	if auditLevel != "none" {
+		auditOut, errc, err := ingestAudit(ctx, auditLevel, head)
+		if err != nil {
+			return errors.Wrap(err, "Error: cannot create audit component: ")
+		}
+		errcList = append(errcList, errc)
+		head = auditOut
 	}
-	errcList = append(errcList, errc)
 
-	errc, err = ingestAuditSink(ctx, auditLevel, lwriterOut)
+	errc, err := ingestReportSink(ctx, env.report, head)
 	if err != nil {
-		return errors.Wrap(err, "Error: cannot create audit-sink component: ")
+		return errors.Wrap(err, "Error: cannot create report-sink component: ")
 	}
 	errcList = append(errcList, errc)
 
 	// monitor progress
 	err = WaitForPipeline(errcList...)
+	if err != nil {
+		return err
+	}
 
-	return err
+	// stages stop quietly on shutdown, so check
+	// whether the caller cancelled the ingest
+	return ctx.Err()
 
 }
+
+//
+// adapts a stage to run in the parallel lanes
+//
+func laneStage(s Stage) ingestStage {
+	return func(ctx context.Context, in <-chan IngestData) (<-chan IngestData, <-chan error, error) {
+		out, errc, err := s.Run(ctx, in)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Error: cannot create %s component: ", s.Name())
+		}
+		return out, errc, nil
+	}
+}
@@ -6,10 +6,49 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 
 	boom "github.com/tylertreat/BoomFilters"
 )
 
+//
+// records and checks the link traces left by objects,
+// satisfied by the shared linkFilter, and by the overlay
+// used by a dry run so the shared filter is left untouched.
+//
+type linkTracer interface {
+	Add(data []byte)
+	Test(data []byte) bool
+}
+
+//
+// the sbf is not safe for concurrent use (even tests update
+// its hashing state), linkFilter guards it so that ingest
+// pipelines running in parallel can share the same set of link traces.
+//
+type linkFilter struct {
+	sync.Mutex
+	sbf *boom.ScalableBloomFilter
+}
+
+//
+// record a link trace
+//
+func (lf *linkFilter) Add(data []byte) {
+	lf.Lock()
+	lf.sbf.Add(data)
+	lf.Unlock()
+}
+
+//
+// check whether a link trace has been recorded
+//
+func (lf *linkFilter) Test(data []byte) bool {
+	lf.Lock()
+	defer lf.Unlock()
+	return lf.sbf.Test(data)
+}
+
 //
 // loads the scalable bloom filter from disk
 //
@@ -18,7 +57,7 @@ import (
 // on ordering of data ingest, all models can loaded
 // in any order or in mixed input files/streams
 //
-func openSBF(folderPath string) *boom.ScalableBloomFilter {
+func openSBF(folderPath string) *linkFilter {
 
 	sbf := boom.NewDefaultScalableBloomFilter(0.01)
 	sbfFile := fmt.Sprintf("%s/sbf/featureLinks.sbf", folderPath)
@@ -32,14 +71,14 @@ func openSBF(folderPath string) *boom.ScalableBloomFilter {
 		}
 		log.Printf("sbf loaded from file: %d bytes.", size)
 	}
-	return sbf
+	return &linkFilter{sbf: sbf}
 
 }
 
 //
 // saves the supplied sbf to disk
 //
-func saveSBF(sbf *boom.ScalableBloomFilter, folderPath string) {
+func saveSBF(lf *linkFilter, folderPath string) {
 
 	sbfPath := fmt.Sprintf("%s/sbf", folderPath)
 	err := os.MkdirAll(sbfPath, os.ModePerm)
@@ -54,7 +93,10 @@ func saveSBF(sbf *boom.ScalableBloomFilter, folderPath string) {
 		log.Println("cannot create sbf file:", err)
 		return
 	}
-	size, err := sbf.WriteTo(f)
+	defer f.Close()
+	lf.Lock()
+	size, err := lf.sbf.WriteTo(f)
+	lf.Unlock()
 	if err != nil {
 		log.Println("cannot save sbf to file: ", err)
 		return
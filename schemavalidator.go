@@ -0,0 +1,166 @@
+// schemavalidator.go
+
+package deep6
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+//
+// actions for an object that fails validation
+// against the schema of its classifier
+//
+const (
+	onInvalidReject     = "reject"
+	onInvalidQuarantine = "quarantine"
+	onInvalidAnnotate   = "annotate"
+)
+
+//
+// key under which the validation errors of an annotated
+// object are stored in its data, alongside is-a
+//
+const schemaErrorsKey = "schema-errors"
+
+//
+// Validates each classified object against the JSON Schema
+// of its classifier, objects whose classifier has no
+// schema are passed on unchecked.
+//
+// An object that fails validation is dealt with according to
+// the OnInvalid setting of its classifier:
+//
+// reject - the object is dropped
+// quarantine - the object is sent to the dead-letter queue,
+// if the ingest is not tolerant it stops the pipeline instead
+// annotate - the object is stored, with its validation errors
+// held in its data under schema-errors
+//
+// every invalid object is recorded in the report.
+//
+// ctx - pipeline management context
+// dlq - dead-letter queue for quarantined objects
+// report - receives the validation failures, can be nil
+// preview - if set invalid objects are always passed on
+// with their errors, so a dry run can show them
+// in - channel providing classified IngestData objects
+//
+func schemaValidator(ctx context.Context, dlq *deadLetterQueue, report *IngestReport, preview bool, in <-chan IngestData) (
+	<-chan IngestData, // emits valid or annotated objects
+	<-chan error, // emits errors encountered to the pipeline
+	error) { // any error encountered when creating this component
+
+	out := make(chan IngestData)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errc)
+
+		clock := stageClockFrom(ctx)
+		for igd := range in {
+			start := time.Now()
+
+			errs, err := validateObject(igd)
+			if err != nil {
+				errc <- errors.Wrap(err, "schemaValidator() cannot validate object:")
+				return
+			}
+			if len(errs) > 0 {
+				igd.SchemaErrors = errs
+				action := onInvalidAction(igd.OnInvalid)
+				report.addInvalid(ValidationFailure{
+					Position:  igd.Position,
+					N3id:      igd.N3id,
+					DataModel: igd.DataModel,
+					Action:    action,
+					Errors:    errs,
+				})
+				switch {
+				case preview:
+				case action == onInvalidAnnotate:
+					igd.RawData[schemaErrorsKey] = errs
+				case action == onInvalidQuarantine:
+					raw, _ := json.Marshal(objectData(igd))
+					reason := fmt.Errorf("object fails %s schema: %s", igd.DataModel, strings.Join(errs, "; "))
+					if !dlq.reject(igd.Position, -1, raw, "schema-validator", reason) {
+						errc <- reason
+						return
+					}
+					clock.add(start)
+					continue
+				default:
+					clock.add(start)
+					continue
+				}
+			}
+
+			clock.add(start)
+			select {
+			case out <- igd: // pass the data on to the next stage
+			case <-ctx.Done(): // listen for pipeline shutdown
+				return
+			}
+		}
+	}()
+
+	return out, errc, nil
+
+}
+
+//
+// returns the errors found validating the object against
+// the schema of its classifier, none if it is valid or
+// there is no schema
+//
+func validateObject(igd IngestData) ([]string, error) {
+
+	if igd.schema == nil {
+		return nil, nil
+	}
+	result, err := igd.schema.Validate(gojsonschema.NewGoLoader(objectData(igd)))
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]string, 0, len(result.Errors()))
+	for _, e := range result.Errors() {
+		errs = append(errs, e.String())
+	}
+	return errs, nil
+
+}
+
+//
+// returns the data of the object as it arrived, without
+// the metadata added by the classifier
+//
+func objectData(igd IngestData) map[string]interface{} {
+
+	data := make(map[string]interface{}, len(igd.RawData))
+	for k, v := range igd.RawData {
+		data[k] = v
+	}
+	delete(data, "is-a")
+	if igd.Unique != "" {
+		delete(data, "unique")
+	}
+	return data
+
+}
+
+//
+// the action for an invalid object, reject if not given
+//
+func onInvalidAction(onInvalid string) string {
+	if onInvalid == "" {
+		return onInvalidReject
+	}
+	return onInvalid
+}
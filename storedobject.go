@@ -0,0 +1,76 @@
+// storedobject.go
+
+package deep6
+
+import (
+	"context"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+)
+
+//
+// Source for the remove pipeline, reads the stored triples,
+// links and edges of the object with the given id from the
+// datastore.
+//
+// The object is not reclassified, its stored values are all
+// strings so reclassifying could give a different id to an
+// object whose id is derived from its content.
+//
+// ctx - context for pipeline management
+// db - badger db holding the object
+// id - the id of the object to remove
+//
+func storedObjectSource(ctx context.Context, db *badger.DB, id string) (
+	<-chan IngestData, // emits the stored object
+	<-chan error, // emits errors encountered to the pipeline
+	error) { // any error encountered when creating this component
+
+	out := make(chan IngestData)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errc)
+
+		triples, links, edges, err := findStoredObject(id, db)
+		if err != nil {
+			errc <- errors.Wrap(err, "cannot read stored object:")
+			return
+		}
+		igd := IngestData{
+			N3id:        id,
+			Triples:     triples,
+			RawData:     make(map[string]interface{}, len(triples)),
+			LinkTriples: make([]Triple, 0),
+			EdgeTriples: make([]Triple, 0),
+		}
+		for _, t := range triples {
+			if t.P == "is-a" {
+				igd.Type = t.O
+			}
+			igd.RawData[t.P] = t.O
+		}
+		// only the links made by the object itself, as
+		// when they were built for it on ingest
+		for _, t := range links {
+			if t.S == id {
+				igd.LinkTriples = append(igd.LinkTriples, t)
+			}
+		}
+		for _, t := range edges {
+			if t.S == id {
+				igd.EdgeTriples = append(igd.EdgeTriples, t)
+			}
+		}
+
+		select {
+		case out <- igd:
+		case <-ctx.Done():
+		}
+	}()
+
+	return out, errc, nil
+
+}
@@ -19,8 +19,14 @@ import (
 // in the order specified as long as there is some
 // forward or backward link available.
 //
+// Edges optionally restricts the links followed at each step,
+// Edges[i] lists the edge labels that can be followed from the
+// objects of TraversalSpec[i] to those of TraversalSpec[i+1]
+// e.g. {"actor.mbox"}; a missing or empty entry follows any link.
+//
 type Traversal struct {
 	TraversalSpec []string
+	Edges         [][]string
 }
 
 //
@@ -35,7 +41,7 @@ func (d6 *Deep6DB) TraversalWithId(id string, t Traversal, filterspec FilterSpec
 
 	defer timeTrack(time.Now(), "TraversalWithId()")
 
-	results, err := traversalWithId(id, t.TraversalSpec, filterspec, d6.db, d6.AuditLevel)
+	results, err := traversalWithId(id, t, filterspec, d6.db, d6.AuditLevel, d6.IncludeVoided)
 	if err != nil {
 		return nil, err
 	}
@@ -44,8 +50,9 @@ func (d6 *Deep6DB) TraversalWithId(id string, t Traversal, filterspec FilterSpec
 
 }
 
-func traversalWithId(id string, traversalspec []string, filterspec FilterSpec, db *badger.DB, auditLevel string) (map[string][]map[string]interface{}, error) {
+func traversalWithId(id string, t Traversal, filterspec FilterSpec, db *badger.DB, auditLevel string, includeVoided bool) (map[string][]map[string]interface{}, error) {
 
+	traversalspec := t.TraversalSpec
 	if len(traversalspec) == 0 {
 		return nil, errors.New("no traversalspec provided")
 	}
@@ -71,7 +78,7 @@ func traversalWithId(id string, traversalspec []string, filterspec FilterSpec, d
 	//
 	// check that the object id matches the first term of the traversal
 	//
-	typeOut, errc, err := traverseTypes(ctx, traversalspec[0], filterspec, db, sourceOut)
+	typeOut, errc, err := traverseTypes(ctx, traversalspec[0], filterspec, includeVoided, db, sourceOut)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error: cannot create traversal-by-type component: ")
 	}
@@ -88,25 +95,29 @@ func traversalWithId(id string, traversalspec []string, filterspec FilterSpec, d
 	//
 	head := typeOut
 	var next_chan <-chan TraversalData
-	for _, specObject := range traversalspec[1:] {
+	for i, specObject := range traversalspec[1:] {
+		var edges []string
+		if i < len(t.Edges) {
+			edges = t.Edges[i]
+		}
 		if next_chan == nil {
-			link_chan, errc, err := traverseLinks(ctx, db, head)
+			link_chan, errc, err := traverseLinks(ctx, db, edges, head)
 			if err != nil {
 				errors.Wrap(err, "Error: cannot create traversal-by-links component: ")
 			}
 			errcList = append(errcList, errc)
-			next_chan, errc, err = traverseTypes(ctx, specObject, filterspec, db, link_chan)
+			next_chan, errc, err = traverseTypes(ctx, specObject, filterspec, includeVoided, db, link_chan)
 			if err != nil {
 				errors.Wrap(err, "Error: cannot create traversal-by-type component: ")
 			}
 			errcList = append(errcList, errc)
 		} else {
-			link_chan, errc, err := traverseLinks(ctx, db, next_chan)
+			link_chan, errc, err := traverseLinks(ctx, db, edges, next_chan)
 			if err != nil {
 				errors.Wrap(err, "Error: cannot create traversal-by-links component: ")
 			}
 			errcList = append(errcList, errc)
-			next_chan, errc, err = traverseTypes(ctx, specObject, filterspec, db, link_chan)
+			next_chan, errc, err = traverseTypes(ctx, specObject, filterspec, includeVoided, db, link_chan)
 			if err != nil {
 				errors.Wrap(err, "Error: cannot create traversal-by-type component: ")
 			}
@@ -154,6 +165,9 @@ func traversalWithId(id string, traversalspec []string, filterspec FilterSpec, d
 // Allows traversal using for example a localid for a user, or a user name
 // as opposed to a unique id
 //
+// Objects are also found by the normalised forms of the value,
+// as given by the normalisers in the classifier config.
+//
 //  val - the value to search for
 //  traversalspec - object types to traverse
 //
@@ -161,11 +175,14 @@ func (d6 *Deep6DB) TraversalWithValue(val string, t Traversal, filterspec Filter
 
 	defer timeTrack(time.Now(), "TraversalWithValue()")
 
-	return traversalWithValue(val, t.TraversalSpec, filterspec, d6.db, d6.AuditLevel)
+	// the value may be held by objects in a normalised form
+	values := d6.Classifiers.config().valueForms(val)
+
+	return traversalWithValue(values, t, filterspec, d6.db, d6.AuditLevel, d6.IncludeVoided)
 
 }
 
-func traversalWithValue(val string, traversalspec []string, filterspec FilterSpec, db *badger.DB, auditLevel string) (map[string][]map[string]interface{}, error) {
+func traversalWithValue(values []string, t Traversal, filterspec FilterSpec, db *badger.DB, auditLevel string, includeVoided bool) (map[string][]map[string]interface{}, error) {
 
 	//
 	// Find the objects that contain the value
@@ -177,11 +194,13 @@ func traversalWithValue(val string, traversalspec []string, filterspec FilterSpe
 		opts.PrefetchValues = false
 		it := txn.NewIterator(opts)
 		defer it.Close()
-		prefix := []byte(fmt.Sprintf("osp|%s", val))
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			t := NewTriple(string(item.Key()))
-			targets[t.S] = struct{}{}
+		for _, val := range values {
+			prefix := []byte(fmt.Sprintf("osp|%s", val))
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				item := it.Item()
+				triple := NewTriple(string(item.Key()))
+				targets[triple.S] = struct{}{}
+			}
 		}
 		return nil
 	})
@@ -193,7 +212,7 @@ func traversalWithValue(val string, traversalspec []string, filterspec FilterSpe
 	// follw the traversal spec for each of the objects
 	//
 	for target, _ := range targets {
-		traversalResults, err := traversalWithId(target, traversalspec, filterspec, db, auditLevel)
+		traversalResults, err := traversalWithId(target, t, filterspec, db, auditLevel, includeVoided)
 		if err != nil {
 			return nil, err
 		}
@@ -10,7 +10,21 @@ import (
 	"github.com/pkg/errors"
 )
 
-func traverseLinks(ctx context.Context, db *badger.DB, in <-chan TraversalData) (
+//
+// finds the objects linked to the targets of the
+// previous stage of a traversal
+//
+// Crosswalk records (see crosswalk.go) are passed through, the
+// objects they link are also targets, as are those of any further
+// crosswalks reached through them.
+//
+// ctx - pipeline management context
+// db - badger db used to find links
+// edges - labels of the edges to follow (see linkedges.go),
+// if empty all links are followed
+// in - channel providing TraversalData
+//
+func traverseLinks(ctx context.Context, db *badger.DB, edges []string, in <-chan TraversalData) (
 	<-chan TraversalData, // pass data on to next stage
 	<-chan error, // emits errors encountered to the pipeline
 	error) {
@@ -18,6 +32,14 @@ func traverseLinks(ctx context.Context, db *badger.DB, in <-chan TraversalData)
 	out := make(chan TraversalData)
 	errc := make(chan error, 1)
 
+	predicates := []string{"references"}
+	if len(edges) > 0 {
+		predicates = make([]string, 0, len(edges))
+		for _, e := range edges {
+			predicates = append(predicates, edgePrefix+edgeLabel(e))
+		}
+	}
+
 	go func() {
 		defer close(out)
 		defer close(errc)
@@ -25,24 +47,60 @@ func traverseLinks(ctx context.Context, db *badger.DB, in <-chan TraversalData)
 		for td := range in {
 			targets := make(map[string]string, 0)
 			err := db.View(func(txn *badger.Txn) error {
+				opts := badger.DefaultIteratorOptions
+				opts.PrefetchValues = false
+				it := txn.NewIterator(opts)
+				defer it.Close()
+				// the nodes linked to a node
+				linked := func(id string) []string {
+					nodes := make([]string, 0)
+					for _, predicate := range predicates {
+						linkinPrefix := []byte(fmt.Sprintf("posl|%s|%s|", predicate, id)) // things that link to this object
+						for it.Seek(linkinPrefix); it.ValidForPrefix(linkinPrefix); it.Next() {
+							item := it.Item()
+							t := NewTriple(string(item.KeyCopy(nil)))
+							nodes = append(nodes, t.S)
+						}
+						linkoutPrefix := []byte(fmt.Sprintf("psol|%s|%s|", predicate, id)) // objects we link to
+						for it.Seek(linkoutPrefix); it.ValidForPrefix(linkoutPrefix); it.Next() {
+							item := it.Item()
+							t := NewTriple(string(item.KeyCopy(nil)))
+							nodes = append(nodes, t.O)
+						}
+					}
+					return nodes
+				}
 				for id, targetType := range td.TraversalStageTargets {
-					opts := badger.DefaultIteratorOptions
-					opts.PrefetchValues = false
-					it := txn.NewIterator(opts)
-					defer it.Close()
-					linkinPrefix := []byte(fmt.Sprintf("posl|references|%s|", id)) // things that link to this object
-					for it.Seek(linkinPrefix); it.ValidForPrefix(linkinPrefix); it.Next() {
-						item := it.Item()
-						t := NewTriple(string(item.KeyCopy(nil)))
-						targets[t.S] = targetType
+					queue := make([]string, 0)
+					for _, node := range linked(id) {
+						targets[node] = targetType
+						queue = append(queue, node)
 					}
-					linkoutPrefix := []byte(fmt.Sprintf("psol|references|%s|", id)) // objects we link to
-					for it.Seek(linkoutPrefix); it.ValidForPrefix(linkoutPrefix); it.Next() {
-						item := it.Item()
-						t := NewTriple(string(item.KeyCopy(nil)))
-						targets[t.O] = targetType
+					// pass through any crosswalks to the
+					// objects they declare the same
+					seen := map[string]struct{}{id: {}}
+					for len(queue) > 0 {
+						node := queue[0]
+						queue = queue[1:]
+						if _, ok := seen[node]; ok {
+							continue
+						}
+						seen[node] = struct{}{}
+						ok, err := isCrosswalk(txn, node)
+						if err != nil {
+							return err
+						}
+						if !ok {
+							continue
+						}
+						for _, same := range linked(node) {
+							if _, ok := seen[same]; ok {
+								continue
+							}
+							targets[same] = targetType
+							queue = append(queue, same)
+						}
 					}
-
 				}
 				return nil
 			})
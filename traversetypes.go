@@ -11,7 +11,7 @@ import (
 	"github.com/pkg/errors"
 )
 
-func traverseTypes(ctx context.Context, objectType string, filterSpec FilterSpec, db *badger.DB, in <-chan TraversalData) (
+func traverseTypes(ctx context.Context, objectType string, filterSpec FilterSpec, includeVoided bool, db *badger.DB, in <-chan TraversalData) (
 	<-chan TraversalData, // pass data on to next stage
 	<-chan error, // emits errors encountered to the pipeline
 	error) {
@@ -50,6 +50,25 @@ func traverseTypes(ctx context.Context, objectType string, filterSpec FilterSpec
 				return
 			}
 
+			//
+			// voided xAPI statements are not part of the graph
+			// unless asked for
+			//
+			if !includeVoided {
+				ids := make([]string, 0, len(matches))
+				for match := range matches {
+					ids = append(ids, match)
+				}
+				voided, err := findVoided(db, ids)
+				if err != nil {
+					errc <- err
+					return
+				}
+				for match := range voided {
+					delete(matches, match)
+				}
+			}
+
 			//
 			// apply filters for objects of this type
 			//
@@ -4,6 +4,7 @@ package deep6
 
 import (
 	"context"
+	"time"
 
 	"github.com/dgraph-io/badger"
 	"github.com/pkg/errors"
@@ -13,6 +14,13 @@ import (
 // commits triples to datastore so can be used in
 // lookups by later pipeline stages.
 //
+// if the object is an update to a stored object only the
+// triples that have been added are written, and those no
+// longer in the object are removed. The stored content hash
+// is removed first, so if the ingest stops before the update
+// is complete the object is relinked in full when it is
+// next ingested (see objectRemover()).
+//
 // ctx - context for pipeline management
 // wb - badger.WriteBatch which manages very fast writing to the
 // datastore
@@ -30,8 +38,28 @@ func tripleWriter(ctx context.Context, wb *badger.WriteBatch, in <-chan IngestDa
 		defer close(out)
 		defer close(errc)
 
+		clock := stageClockFrom(ctx)
 		for igd := range in {
-			for _, t := range igd.Triples {
+			start := time.Now()
+			igd.AddedTriples, igd.RemovedTriples = diffTriples(igd.StoredTriples, igd.Triples)
+			if igd.Replaced {
+				// the new hash is written once the object is linked
+				err := wb.Delete([]byte(contentHashPrefix + igd.N3id))
+				if err != nil {
+					errc <- errors.Wrap(err, "error removing content hash from datastore:")
+					return
+				}
+			}
+			for _, t := range igd.RemovedTriples {
+				for _, hexa := range t.Sextuple() {
+					err := wb.Delete([]byte(hexa))
+					if err != nil {
+						errc <- errors.Wrap(err, "error removing triple from datastore:")
+						return
+					}
+				}
+			}
+			for _, t := range igd.AddedTriples {
 				for _, hexa := range t.Sextuple() { // turn each tuple into hexastore entries
 					err := wb.Set([]byte(hexa), []byte{})
 					if err != nil {
@@ -40,6 +68,7 @@ func tripleWriter(ctx context.Context, wb *badger.WriteBatch, in <-chan IngestDa
 					}
 				}
 			}
+			clock.add(start)
 			select {
 			case out <- igd: // pass the data on to the next stage
 			case <-ctx.Done(): // listen for pipeline shutdown
@@ -5,6 +5,7 @@ package deep6
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
 //
@@ -26,7 +27,9 @@ func tupleGenerator(ctx context.Context, in <-chan IngestData) (
 		defer close(out)
 		defer close(errc)
 
+		clock := stageClockFrom(ctx)
 		for igd := range in {
+			start := time.Now()
 			m := igd.RawData
 			m = Flatten(m) // turn json into predicate:object pairs
 			igd.RawData = m
@@ -42,8 +45,24 @@ func tupleGenerator(ctx context.Context, in <-chan IngestData) (
 				tuples = append(tuples, t)
 			}
 
+			// add the normalised form of any link values
+			// that have normalisers
+			if len(igd.Normalise) > 0 {
+				specs := parseLinkSpecs(igd.LinkSpecs, igd.Normalise)
+				for _, t := range tuples {
+					spec := matchLinkSpecs(specs, t.P, igd.LinkSuffixes)
+					if spec == nil {
+						continue
+					}
+					if nt, ok := spec.normalisedTriple(t); ok {
+						tuples = append(tuples, nt)
+					}
+				}
+			}
+
 			igd.Triples = tuples
 
+			clock.add(start)
 			select {
 			case out <- igd: // pass the data on to the next stage
 			case <-ctx.Done(): // listen for pipeline shutdown
@@ -0,0 +1,186 @@
+// xapi.go
+
+package deep6
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+)
+
+//
+// verb of an xAPI statement that voids another statement
+//
+const xapiVoidedVerb = "http://adlnet.gov/expapi/verbs/voided"
+
+//
+// key prefix marking a statement as voided,
+// the value is the id of the voiding statement
+//
+const voidedPrefix = "void|"
+
+//
+// Applies the xAPI rules for statements that refer to other statements.
+//
+// A statement whose object is a StatementRef is linked explicitly to
+// the statement it refers to, whichever order the two arrive in.
+// A statement with the voided verb also marks the statement it refers
+// to as voided, voided statements are hidden from queries and
+// traversals unless Deep6DB.IncludeVoided is set. When a voiding
+// statement is updated the void it made is lifted, and made again
+// if the new version is still voiding.
+//
+// ctx - pipeline management context
+// wb - badger.WriteBatch for writing voided markers
+// in - channel providing IngestData objects
+//
+func xapiStatements(ctx context.Context, wb batchWriter, in <-chan IngestData) (
+	<-chan IngestData, // pass on to next stage
+	<-chan error, // emits errors encountered to the pipeline
+	error) { // returns any error encountered creating this component
+
+	out := make(chan IngestData)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errc)
+
+		clock := stageClockFrom(ctx)
+		for igd := range in {
+			start := time.Now()
+			// an update lifts the void made by the stored
+			// statement, the new one marks its target below
+			if igd.Replaced {
+				if target := storedVoidTarget(igd.StoredTriples); target != "" {
+					if err := wb.Delete([]byte(voidedPrefix + target)); err != nil {
+						errc <- errors.Wrap(err, "error removing voided statement marker:")
+						return
+					}
+				}
+			}
+			if igd.DataModel == "XAPI" {
+				if ref := statementRef(igd.RawData); ref != "" {
+					igd.ExplicitLinks = append(igd.ExplicitLinks, ref)
+				}
+				if target := voidTarget(igd.RawData); target != "" {
+					err := wb.Set([]byte(voidedPrefix+target), []byte(igd.N3id))
+					if err != nil {
+						errc <- errors.Wrap(err, "error writing voided statement marker:")
+						return
+					}
+				}
+			}
+			clock.add(start)
+			select {
+			case out <- igd: // pass the data on to the next stage
+			case <-ctx.Done(): // listen for pipeline shutdown
+				return
+			}
+		}
+	}()
+
+	return out, errc, nil
+
+}
+
+//
+// returns the id of the statement referred to by an
+// xAPI statement, empty if its object is not a StatementRef.
+//
+func statementRef(statement map[string]interface{}) string {
+
+	object, ok := statement["object"].(map[string]interface{})
+	if !ok || object["objectType"] != "StatementRef" {
+		return ""
+	}
+	id, ok := object["id"].(string)
+	if !ok {
+		return ""
+	}
+	return id
+
+}
+
+//
+// returns the id of the statement voided by an xAPI
+// statement, empty if it is not a voiding statement.
+//
+func voidTarget(statement map[string]interface{}) string {
+
+	verb, ok := statement["verb"].(map[string]interface{})
+	if !ok || verb["id"] != xapiVoidedVerb {
+		return ""
+	}
+	return statementRef(statement)
+
+}
+
+//
+// returns those of the given ids that are voided statements
+//
+func findVoided(db *badger.DB, ids []string) (map[string]struct{}, error) {
+
+	voided := make(map[string]struct{})
+	err := db.View(func(txn *badger.Txn) error {
+		for _, id := range ids {
+			_, err := txn.Get([]byte(voidedPrefix + id))
+			if err == badger.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			voided[id] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot check for voided statements:")
+	}
+
+	return voided, nil
+
+}
+
+//
+// removes voided statements from a list of ids
+//
+func withoutVoided(db *badger.DB, ids []string) ([]string, error) {
+
+	voided, err := findVoided(db, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(voided) == 0 {
+		return ids, nil
+	}
+	kept := make([]string, 0, len(ids)-len(voided))
+	for _, id := range ids {
+		if _, ok := voided[id]; !ok {
+			kept = append(kept, id)
+		}
+	}
+	return kept, nil
+
+}
+
+//
+// returns the id of the statement voided by the stored
+// version of a statement, from its triples; empty if
+// it was not a voiding statement.
+//
+func storedVoidTarget(triples []Triple) string {
+
+	values := make(map[string]string, len(triples))
+	for _, t := range triples {
+		values[t.P] = t.O
+	}
+	if values["verb.id"] != xapiVoidedVerb || values["object.objectType"] != "StatementRef" {
+		return ""
+	}
+	return values["object.id"]
+
+}
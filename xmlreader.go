@@ -0,0 +1,381 @@
+// xmlreader.go
+
+package deep6
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//
+// namespace of xml-schema instance attributes (xsi:type, xsi:nil etc.)
+// these are schema mechanics rather than data, so are not
+// carried into the json objects
+//
+const xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+//
+// Iterator for xml objects presented through a reader; file,
+// http request, stdin etc.
+//
+// Reads SIF xml data from a stream, converts each object into
+// the json form used by the rest of the ingest pipeline and
+// feeds it to the next stage.
+//
+// The stream can contain a single object (e.g. <StudentPersonal>),
+// a collection envelope (e.g. <StudentPersonals>) in which case
+// each member of the collection is emitted as its own object, or
+// a sequence of either. Envelopes are recognised by the collection
+// rules of the classifier config, as for json.
+//
+// Conversion follows the SIF json conventions:
+// attributes become properties of the element object,
+// the text content of an element with attributes is held in 'value',
+// repeated elements and members of SIF lists (e.g. OtherIdList)
+// become arrays.
+//
+// Malformed xml ends the stream, as the decoder cannot read past
+// it. In a tolerant ingest the object is captured in the dead-letter
+// queue along with the rest of the stream, the objects before it are
+// ingested and the report is marked as truncated, otherwise the
+// ingest fails.
+//
+// ctx - required context for pipeline management
+// r - reader accessing xml data
+// rules - the collection rules for recognising envelopes
+// dlq - dead-letter queue for malformed xml, if nil
+// malformed xml stops the pipeline
+//
+func xmlReaderSource(ctx context.Context, r io.Reader, rules []collectionRule, dlq *deadLetterQueue) (
+	<-chan IngestData, // source emits json objects read from xml as map in IngestData
+	<-chan error, // emits any errors encountered to the pipeline
+	error) { // any error when creating the source stage itself
+
+	out := make(chan IngestData)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errc)
+
+		rec := &xmlRecorder{r: bufio.NewReader(r)}
+		d := xml.NewDecoder(rec)
+		tokens := &xmlTokens{d: d}
+		position := 0
+
+		// emit sends a converted object on to the next stage,
+		// returns false if the pipeline has been shut down
+		emit := func(m map[string]interface{}) bool {
+			select {
+			case out <- IngestData{RawData: m, Position: position}: // pass the map onto the next stage
+				position++
+				return true
+			case <-ctx.Done(): // listen for pipeline shutdown
+				return false
+			}
+		}
+
+		// fail reports malformed xml, capturing the raw xml of the
+		// object read so far and the rest of the stream; the
+		// decoder cannot recover from it so the stream ends here
+		fail := func(err error, raw []byte) {
+			raw = append(raw, rec.rest()...)
+			if !dlq.rejectAs(DeadLetterXML, position, d.InputOffset(), raw, "xml-reader", err) {
+				errc <- err
+				return
+			}
+			dlq.truncated()
+		}
+
+		for {
+			tok, err := tokens.Token()
+			rootStart := tokens.start
+			rec.discard(rootStart) // only the current object is kept
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				fail(errors.Wrap(err, "unable to read xml token."), rec.since(rootStart))
+				return
+			}
+			root, ok := tok.(xml.StartElement)
+			if !ok {
+				continue // skip prolog, comments, whitespace etc.
+			}
+			// start tag of the element, which opens the raw
+			// xml of a bad member if the element is an envelope
+			rootTag := append([]byte{}, rec.since(rootStart)...)
+
+			//
+			// collection envelopes are read member by member
+			// so large collections are never held in memory;
+			// an element is an envelope if the collection rules
+			// unwrap it into members named as its first child
+			//
+			first, err := tokens.firstChild()
+			if err != nil {
+				fail(errors.Wrap(err, "unable to read xml object: "+root.Name.Local), rec.since(rootStart))
+				return
+			}
+			if isXMLCollection(rules, root.Name.Local, first) {
+				for {
+					tok, err := tokens.Token()
+					memberStart := tokens.start
+					rec.discard(memberStart)
+					if err != nil {
+						fail(errors.Wrap(err, "unable to read xml collection: "+root.Name.Local),
+							append(rootTag, rec.since(memberStart)...))
+						return
+					}
+					if _, ok := tok.(xml.EndElement); ok {
+						break // end of envelope
+					}
+					member, ok := tok.(xml.StartElement)
+					if !ok {
+						continue
+					}
+					v, err := decodeXMLElement(tokens, member)
+					if err != nil {
+						fail(errors.Wrap(err, "unable to decode xml object: "+member.Name.Local),
+							append(rootTag, rec.since(memberStart)...))
+						return
+					}
+					if !emit(map[string]interface{}{member.Name.Local: v}) {
+						return
+					}
+				}
+				continue
+			}
+
+			v, err := decodeXMLElement(tokens, root)
+			if err != nil {
+				fail(errors.Wrap(err, "unable to decode xml object: "+root.Name.Local), rec.since(rootStart))
+				return
+			}
+			if !emit(map[string]interface{}{root.Name.Local: v}) {
+				return
+			}
+		}
+	}()
+
+	return out, errc, nil
+}
+
+//
+// reports whether an element is a collection envelope, one that
+// the collection rules unwrap into members with the name of its
+// first child element; an envelope with no members is empty.
+//
+func isXMLCollection(rules []collectionRule, envelope, firstChild string) bool {
+
+	if firstChild != "" {
+		return isCollectionMember(rules, envelope, firstChild)
+	}
+	for _, rule := range rules {
+		if _, ok := matchEnvelope(rule.Envelope, envelope); ok && rule.Member != "" {
+			return true
+		}
+	}
+	return false
+
+}
+
+//
+// token reader over the xml decoder that puts back the
+// tokens read ahead to find the first child of an element
+//
+type xmlTokens struct {
+	d       *xml.Decoder
+	pending []xml.Token
+	// stream offsets at which the pending tokens start
+	offsets []int64
+	// stream offset at which the token last read starts
+	start int64
+}
+
+func (t *xmlTokens) Token() (xml.Token, error) {
+
+	if len(t.pending) > 0 {
+		tok := t.pending[0]
+		t.start = t.offsets[0]
+		t.pending = t.pending[1:]
+		t.offsets = t.offsets[1:]
+		return tok, nil
+	}
+	t.start = t.d.InputOffset()
+	return t.d.Token()
+
+}
+
+//
+// reads ahead to the first child element of the element just
+// started, returns its name or empty if the element has no
+// child elements; the tokens read are put back.
+//
+func (t *xmlTokens) firstChild() (string, error) {
+
+	for {
+		offset := t.d.InputOffset()
+		tok, err := t.d.Token()
+		if err != nil {
+			return "", err
+		}
+		t.pending = append(t.pending, xml.CopyToken(tok))
+		t.offsets = append(t.offsets, offset)
+		switch e := tok.(type) {
+		case xml.StartElement:
+			return e.Name.Local, nil
+		case xml.EndElement:
+			return "", nil
+		}
+	}
+
+}
+
+//
+// reader under the xml decoder that keeps the bytes it has
+// passed on, from a given stream offset, so that the raw xml
+// of a malformed object can be captured.
+//
+// As it is a ByteReader the decoder reads from it directly,
+// without buffering, so the offsets of the decoder are those
+// of the bytes read from it.
+//
+type xmlRecorder struct {
+	r *bufio.Reader
+	// bytes read from the stream offset base onwards
+	buf  []byte
+	base int64
+}
+
+func (rec *xmlRecorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	rec.buf = append(rec.buf, p[:n]...)
+	return n, err
+}
+
+func (rec *xmlRecorder) ReadByte() (byte, error) {
+	b, err := rec.r.ReadByte()
+	if err == nil {
+		rec.buf = append(rec.buf, b)
+	}
+	return b, err
+}
+
+//
+// drops the bytes read before the stream offset
+//
+func (rec *xmlRecorder) discard(offset int64) {
+	if n := int(offset - rec.base); n > 0 && n <= len(rec.buf) {
+		rec.buf = append(rec.buf[:0], rec.buf[n:]...)
+		rec.base = offset
+	}
+}
+
+//
+// returns the bytes read from the stream offset onwards
+//
+func (rec *xmlRecorder) since(offset int64) []byte {
+	n := int(offset - rec.base)
+	if n < 0 || n > len(rec.buf) {
+		return rec.buf
+	}
+	return rec.buf[n:]
+}
+
+//
+// returns the bytes not yet read from the stream
+//
+func (rec *xmlRecorder) rest() []byte {
+	raw, _ := ioutil.ReadAll(rec.r)
+	return raw
+}
+
+//
+// reads the element started by start from the decoder
+// and returns its json representation;
+// a string for simple elements, otherwise a map of
+// attributes and child elements.
+//
+func decodeXMLElement(d xml.TokenReader, start xml.StartElement) (interface{}, error) {
+
+	obj := make(map[string]interface{})
+	isNil := false
+
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			continue
+		}
+		if attr.Name.Space == xsiNamespace {
+			if attr.Name.Local == "nil" && attr.Value == "true" {
+				isNil = true
+			}
+			continue
+		}
+		obj[attr.Name.Local] = attr.Value
+	}
+
+	isList := strings.HasSuffix(start.Name.Local, "List")
+	hasChildren := false
+	var text strings.Builder
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			hasChildren = true
+			v, err := decodeXMLElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+			addXMLChild(obj, t.Name.Local, v, isList)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			if isNil {
+				return "", nil // nil elements carry no value
+			}
+			if !hasChildren && len(obj) == 0 {
+				return value, nil // simple element
+			}
+			if len(value) > 0 {
+				obj["value"] = value
+			}
+			return obj, nil
+		}
+	}
+
+}
+
+//
+// adds a child element to its parent object, repeated
+// elements (and all members of a SIF list) become arrays
+//
+func addXMLChild(obj map[string]interface{}, name string, v interface{}, asArray bool) {
+
+	existing, found := obj[name]
+	if !found {
+		if asArray {
+			obj[name] = []interface{}{v}
+		} else {
+			obj[name] = v
+		}
+		return
+	}
+	if arr, ok := existing.([]interface{}); ok {
+		obj[name] = append(arr, v)
+		return
+	}
+	obj[name] = []interface{}{existing, v}
+
+}
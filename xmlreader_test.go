@@ -0,0 +1,213 @@
+// xmlreader_test.go
+
+package deep6
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeXMLElement(t *testing.T) {
+
+	tests := []struct {
+		name string
+		xml  string
+		want string // json of the decoded element
+	}{
+		{"simple element", `<LocalId>L001</LocalId>`, `"L001"`},
+		{"text is trimmed", `<LocalId>
+			L001
+		</LocalId>`, `"L001"`},
+		{"empty element", `<LocalId/>`, `""`},
+		{"attributes", `<StudentPersonal RefId="P1"><LocalId>L001</LocalId></StudentPersonal>`,
+			`{"LocalId":"L001","RefId":"P1"}`},
+		{"text with attributes", `<OtherId Type="0004">123</OtherId>`, `{"Type":"0004","value":"123"}`},
+		{"repeated elements", `<Name><GivenName>Ann</GivenName><GivenName>Marie</GivenName></Name>`,
+			`{"GivenName":["Ann","Marie"]}`},
+		{"list members are arrays", `<OtherIdList><OtherId Type="0004">123</OtherId></OtherIdList>`,
+			`{"OtherId":[{"Type":"0004","value":"123"}]}`},
+		{"nested", `<PersonInfo><Name Type="LGL"><FamilyName>Smith</FamilyName></Name></PersonInfo>`,
+			`{"Name":{"FamilyName":"Smith","Type":"LGL"}}`},
+		{"namespaces dropped", `<StudentPersonal xmlns="http://www.sifassociation.org/au/datamodel/3.4" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" RefId="P1"/>`,
+			`{"RefId":"P1"}`},
+		{"xsi type dropped", `<Value xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="xs:string">x</Value>`, `"x"`},
+		{"xsi nil", `<BirthDate xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:nil="true"/>`, `""`},
+		{"nil child", `<PersonInfo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><BirthDate xsi:nil="true"/></PersonInfo>`,
+			`{"BirthDate":""}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := xml.NewDecoder(strings.NewReader(tt.xml))
+			var start xml.StartElement
+			for {
+				tok, err := d.Token()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if s, ok := tok.(xml.StartElement); ok {
+					start = s
+					break
+				}
+			}
+			v, err := decodeXMLElement(d, start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestXMLReaderEnvelopes(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		xml   string
+		rules []collectionRule
+		want  []string // top level key of each object read
+	}{
+		{"single object", `<StudentPersonal RefId="P1"/>`, defaultCollectionRules,
+			[]string{"StudentPersonal"}},
+		{"collection", `<StudentPersonals><StudentPersonal RefId="P1"/><StudentPersonal RefId="P2"/></StudentPersonals>`, defaultCollectionRules,
+			[]string{"StudentPersonal", "StudentPersonal"}},
+		{"empty collection", `<StudentPersonals/>`, defaultCollectionRules,
+			nil},
+		{"plural object is not a collection", `<Settings><Color>red</Color><Size>big</Size></Settings>`, defaultCollectionRules,
+			[]string{"Settings"}},
+		{"sequence", `<SchoolInfo RefId="S1"/><StudentPersonals><StudentPersonal RefId="P1"/></StudentPersonals><SchoolInfo RefId="S2"/>`, defaultCollectionRules,
+			[]string{"SchoolInfo", "StudentPersonal", "SchoolInfo"}},
+		{"configured envelope", `<Batch><Lesson id="1"/><Lesson id="2"/></Batch>`, []collectionRule{{Envelope: "Batch", Member: "Lesson"}},
+			[]string{"Lesson", "Lesson"}},
+		{"no rules", `<StudentPersonals><StudentPersonal RefId="P1"/></StudentPersonals>`, nil,
+			[]string{"StudentPersonals"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			out, errc, err := xmlReaderSource(ctx, strings.NewReader(tt.xml), tt.rules, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0)
+			for igd := range out {
+				for k := range igd.RawData {
+					got = append(got, k)
+				}
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestXMLReaderMalformed(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		xml     string
+		want    []string // RefIds of the objects read before the bad one
+		wantRaw string   // raw xml captured
+	}{
+		{"bad object",
+			`<SchoolInfo RefId="S1"/><StudentPersonal RefId="P1"><LocalId>L1</Local></StudentPersonal><SchoolInfo RefId="S2"/>`,
+			[]string{"S1"},
+			`<StudentPersonal RefId="P1"><LocalId>L1</Local></StudentPersonal><SchoolInfo RefId="S2"/>`},
+		{"bad member",
+			`<StudentPersonals xmlns="http://www.sifassociation.org/au/datamodel/3.4">
+  <StudentPersonal RefId="P1"/>
+  <StudentPersonal RefId="P2"><LocalId>L2</Local></StudentPersonal>
+  <StudentPersonal RefId="P3"/>
+</StudentPersonals>`,
+			[]string{"P1"},
+			`<StudentPersonals xmlns="http://www.sifassociation.org/au/datamodel/3.4"><StudentPersonal RefId="P2"><LocalId>L2</Local></StudentPersonal>
+  <StudentPersonal RefId="P3"/>
+</StudentPersonals>`},
+		{"bad first member",
+			`<StudentPersonals><StudentPersonal RefId="P1"><LocalId>L1</Local></StudentPersonal></StudentPersonals>`,
+			nil,
+			`<StudentPersonals><StudentPersonal RefId="P1"><LocalId>L1</Local></StudentPersonal></StudentPersonals>`},
+		{"truncated",
+			`<SchoolInfo RefId="S1"/><StudentPersonal RefId="P1"><LocalId>L1`,
+			[]string{"S1"},
+			`<StudentPersonal RefId="P1"><LocalId>L1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "deep6-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			file := filepath.Join(dir, "dlq.json")
+			dlq := &deadLetterQueue{file: file, report: newIngestReport()}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			out, errc, err := xmlReaderSource(ctx, strings.NewReader(tt.xml), defaultCollectionRules, dlq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0)
+			for igd := range out {
+				for _, v := range igd.RawData {
+					got = append(got, v.(map[string]interface{})["RefId"].(string))
+				}
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("read %v, want %v", got, tt.want)
+			}
+			dls, err := readDeadLetterFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dls) != 1 {
+				t.Fatalf("%d dead letters, want 1", len(dls))
+			}
+			if dls[0].Raw != tt.wantRaw {
+				t.Errorf("captured %q, want %q", dls[0].Raw, tt.wantRaw)
+			}
+			if !dlq.report.Truncated {
+				t.Error("report not marked as truncated")
+			}
+			if dls[0].Format != DeadLetterXML || dls[0].Position != len(tt.want) {
+				t.Errorf("captured as %s at %d, want %s at %d", dls[0].Format, dls[0].Position, DeadLetterXML, len(tt.want))
+			}
+		})
+	}
+
+	// without a dead-letter queue malformed xml fails the ingest
+	out, errc, err := xmlReaderSource(context.Background(), strings.NewReader(tests[0].xml), defaultCollectionRules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range out {
+	}
+	if err := <-errc; err == nil {
+		t.Error("malformed xml did not fail the ingest")
+	}
+
+}